@@ -0,0 +1,153 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"cube/task"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// cronCmd represents the cron command
+var cronCmd = &cobra.Command{
+	Use:   "cron",
+	Short: "Manage cron jobs.",
+	Long: `cube cron command.
+
+A cron job runs a task or a job from a template on a cron schedule.`,
+}
+
+// cronCreateCmd represents the cron create command
+var cronCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a cron job.",
+	Long: `cube cron create command.
+
+The create command sends a cron job specification to the manager, which
+starts a run each time the schedule fires.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		filename, _ := cmd.Flags().GetString("filename")
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			log.Fatalf("Unable to read file: %v", filename)
+		}
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		var c task.CronJob
+		if err := d.Decode(&c); err != nil {
+			log.Fatalf("Invalid cron job specification in %s: %v", filename, err)
+		}
+
+		url := fmt.Sprintf("http://%s/cronjobs", manager)
+		resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			log.Fatalf("Error creating cron job: %s", errorMessage(resp))
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&c)
+		if err != nil {
+			log.Fatalf("Error decoding response: %v", err)
+		}
+		log.Printf("Created cron job %s (%v), next run at %v\n", c.Name, c.ID, c.NextScheduleTime)
+	},
+}
+
+// cronListCmd represents the cron list command
+var cronListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List cron jobs.",
+	Long: `cube cron list command.
+
+The list command shows each cron job's schedule, when it last and next
+runs, and how many of its runs are active.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		url := fmt.Sprintf("http://%s/cronjobs", manager)
+
+		resp, err := http.Get(url)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Error listing cron jobs: %s", errorMessage(resp))
+		}
+
+		var cronJobs []*task.CronJob
+		err = json.NewDecoder(resp.Body).Decode(&cronJobs)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "ID\tNAME\tSCHEDULE\tTIMEZONE\tLAST\tNEXT\tACTIVE\t")
+		for _, c := range cronJobs {
+			tz := c.TimeZone
+			if tz == "" {
+				tz = "UTC"
+			}
+			last, next := "-", "-"
+			if !c.LastScheduleTime.IsZero() {
+				last = time.Since(c.LastScheduleTime).Round(time.Second).String() + " ago"
+			}
+			if !c.NextScheduleTime.IsZero() {
+				next = "in " + time.Until(c.NextScheduleTime).Round(time.Second).String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t\n", c.ID, c.Name, c.Schedule, tz, last, next, len(c.ActiveRuns()))
+		}
+		w.Flush()
+	},
+}
+
+// cronDeleteCmd represents the cron delete command
+var cronDeleteCmd = &cobra.Command{
+	Use:   "delete <cronJobID>",
+	Short: "Delete a cron job.",
+	Long: `cube cron delete command.
+
+The delete command stops a cron job from starting any more runs. Runs that
+have already started are left to finish.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		url := fmt.Sprintf("http://%s/cronjobs/%s", manager, args[0])
+
+		req, err := http.NewRequest("DELETE", url, nil)
+		if err != nil {
+			log.Fatalf("Error creating request %v: %v", url, err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			log.Fatalf("Error deleting cron job: %s", errorMessage(resp))
+		}
+
+		log.Printf("Cron job %v has been deleted.", args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cronCmd)
+	cronCmd.AddCommand(cronCreateCmd, cronListCmd, cronDeleteCmd)
+
+	cronCmd.PersistentFlags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	cronCreateCmd.Flags().StringP("filename", "f", "cronjob.json", "Cron job specification file")
+}
@@ -0,0 +1,146 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"cube/stream"
+	"cube/worker"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:   "exec [-i] [-t] <taskID> -- <cmd> [args...]",
+	Short: "Run a command in a running task.",
+	Long: `cube exec command.
+
+The exec command runs a command inside a running task's container. The
+request goes through the manager to the worker running the task. Use -i to
+send your input to the command and -t to give it a terminal.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		interactive, _ := cmd.Flags().GetBool("interactive")
+		tty, _ := cmd.Flags().GetBool("tty")
+
+		stdinFd := int(os.Stdin.Fd())
+		stdoutFd := int(os.Stdout.Fd())
+		if tty && !term.IsTerminal(stdinFd) {
+			log.Fatal("--tty requires standard input to be a terminal")
+		}
+
+		req := worker.ExecRequest{Cmd: args[1:], Tty: tty, Stdin: interactive}
+		if tty {
+			width, height, err := term.GetSize(stdoutFd)
+			if err == nil {
+				req.Width, req.Height = uint16(width), uint16(height)
+			}
+		}
+		body, err := json.Marshal(req)
+		if err != nil {
+			log.Fatalf("Unable to marshal exec request: %v", err)
+		}
+
+		url := fmt.Sprintf("http://%s/tasks/%s/exec", manager, args[0])
+		httpReq, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			log.Fatalf("Error creating request %v: %v", url, err)
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("Connection", "Upgrade")
+		httpReq.Header.Set("Upgrade", stream.Protocol)
+
+		resp, err := http.DefaultClient.Do(httpReq)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			e := worker.ErrResponse{}
+			err := json.NewDecoder(resp.Body).Decode(&e)
+			if err != nil {
+				log.Fatalf("Error starting exec: %v", resp.Status)
+			}
+			log.Fatalf("Error starting exec: %s", e.Message)
+		}
+		conn, ok := resp.Body.(io.ReadWriteCloser)
+		if !ok {
+			log.Fatal("Manager did not upgrade the connection")
+		}
+
+		mux := stream.NewMux(conn)
+		if interactive {
+			go func() {
+				io.Copy(mux.Writer(stream.Stdin), os.Stdin)
+				mux.WriteFrame(stream.Stdin, nil)
+			}()
+		}
+
+		var oldState *term.State
+		if tty {
+			oldState, err = term.MakeRaw(stdinFd)
+			if err != nil {
+				log.Fatalf("Error setting terminal to raw mode: %v", err)
+			}
+
+			winch := make(chan os.Signal, 1)
+			signal.Notify(winch, syscall.SIGWINCH)
+			go func() {
+				for range winch {
+					width, height, err := term.GetSize(stdoutFd)
+					if err == nil {
+						mux.WriteResize(uint16(height), uint16(width))
+					}
+				}
+			}()
+		}
+
+		code := readExecOutput(conn)
+		if oldState != nil {
+			term.Restore(stdinFd, oldState)
+		}
+		os.Exit(code)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+
+	execCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	execCmd.Flags().BoolP("interactive", "i", false, "Send standard input to the command")
+	execCmd.Flags().BoolP("tty", "t", false, "Allocate a terminal for the command")
+}
+
+// readExecOutput copies output frames to stdout and stderr until the exit
+// frame arrives, and returns the command's exit code.
+func readExecOutput(r io.Reader) int {
+	for {
+		s, payload, err := stream.ReadFrame(r)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Connection lost: %v\r\n", err)
+			return 1
+		}
+
+		switch s {
+		case stream.Stdout:
+			os.Stdout.Write(payload)
+		case stream.Stderr:
+			os.Stderr.Write(payload)
+		case stream.Exit:
+			return stream.ExitCode(payload)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"cube/task"
+	"cube/worker"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// jobCmd represents the job command
+var jobCmd = &cobra.Command{
+	Use:   "job",
+	Short: "Run and inspect batch jobs.",
+	Long: `cube job command.
+
+A job runs tasks from a template until enough of them have exited 0, rather
+than keeping them running like a service.`,
+}
+
+// jobRunCmd represents the job run command
+var jobRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run a new job.",
+	Long: `cube job run command.
+
+The run command sends a job specification to the manager, which starts its
+tasks.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		filename, _ := cmd.Flags().GetString("filename")
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			log.Fatalf("Unable to read file: %v", filename)
+		}
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		var j task.Job
+		if err := d.Decode(&j); err != nil {
+			log.Fatalf("Invalid job specification in %s: %v", filename, err)
+		}
+
+		url := fmt.Sprintf("http://%s/jobs", manager)
+		resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			log.Fatalf("Error starting job: %s", errorMessage(resp))
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&j)
+		if err != nil {
+			log.Fatalf("Error decoding response: %v", err)
+		}
+		log.Printf("Started job %s (%v)\n", j.Name, j.ID)
+	},
+}
+
+// jobListCmd represents the job list command
+var jobListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List jobs.",
+	Long: `cube job list command.
+
+The list command shows each job's state and how many of its tasks are
+active, have succeeded and have failed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		url := fmt.Sprintf("http://%s/jobs", manager)
+
+		resp, err := http.Get(url)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Error listing jobs: %s", errorMessage(resp))
+		}
+
+		var jobs []*task.Job
+		err = json.NewDecoder(resp.Body).Decode(&jobs)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "ID\tNAME\tSTATE\tCOMPLETIONS\tACTIVE\tFAILED\tAGE\tMESSAGE\t")
+		for _, j := range jobs {
+			age := "-"
+			if !j.StartTime.IsZero() {
+				age = time.Since(j.StartTime).Round(time.Second).String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\t%d\t%d\t%s\t%s\t\n", j.ID, j.Name, j.State, j.Succeeded, j.Completions, j.Active, j.Failed, age, j.Message)
+		}
+		w.Flush()
+	},
+}
+
+// jobStopCmd represents the job stop command
+var jobStopCmd = &cobra.Command{
+	Use:   "stop <jobID>",
+	Short: "Stop a running job.",
+	Long: `cube job stop command.
+
+The stop command fails a job that has not finished and stops its tasks.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		url := fmt.Sprintf("http://%s/jobs/%s", manager, args[0])
+
+		req, err := http.NewRequest("DELETE", url, nil)
+		if err != nil {
+			log.Fatalf("Error creating request %v: %v", url, err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			log.Fatalf("Error stopping job: %s", errorMessage(resp))
+		}
+
+		log.Printf("Job %v has been stopped.", args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(jobCmd)
+	jobCmd.AddCommand(jobRunCmd, jobListCmd, jobStopCmd)
+
+	jobCmd.PersistentFlags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	jobRunCmd.Flags().StringP("filename", "f", "job.json", "Job specification file")
+}
+
+// errorMessage returns the message of an error response from the manager.
+func errorMessage(resp *http.Response) string {
+	e := worker.ErrResponse{}
+	err := json.NewDecoder(resp.Body).Decode(&e)
+	if err != nil || e.Message == "" {
+		return resp.Status
+	}
+
+	return e.Message
+}
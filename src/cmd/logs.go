@@ -0,0 +1,72 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"cube/worker"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// logsCmd represents the logs command
+var logsCmd = &cobra.Command{
+	Use:   "logs [-f] <taskID>",
+	Short: "Print the logs of a task.",
+	Long: `cube logs command.
+
+The logs command prints the output of a task, fetched from the worker running
+it through the manager. With --follow it keeps streaming new output.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		follow, _ := cmd.Flags().GetBool("follow")
+		tail, _ := cmd.Flags().GetString("tail")
+		since, _ := cmd.Flags().GetString("since")
+
+		query := url.Values{}
+		query.Set("follow", strconv.FormatBool(follow))
+		query.Set("tail", tail)
+		if since != "" {
+			query.Set("since", since)
+		}
+		u := fmt.Sprintf("http://%s/tasks/%s/logs?%s", manager, args[0], query.Encode())
+
+		resp, err := http.Get(u)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			e := worker.ErrResponse{}
+			err := json.NewDecoder(resp.Body).Decode(&e)
+			if err != nil {
+				log.Fatalf("Error getting logs: %v", resp.Status)
+			}
+			log.Fatalf("Error getting logs: %s", e.Message)
+		}
+
+		_, err = io.Copy(os.Stdout, resp.Body)
+		if err != nil {
+			log.Fatalf("Error reading logs: %v", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+
+	logsCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	logsCmd.Flags().BoolP("follow", "f", false, "Keep streaming new output")
+	logsCmd.Flags().String("tail", "all", "Number of lines to show from the end of the logs")
+	logsCmd.Flags().String("since", "", "Only show output since a timestamp (RFC 3339) or relative duration (e.g. 10m)")
+}
@@ -37,6 +37,10 @@ The manager controls the orchestration system and is responsible for:
 		go m.ProcessTasks()
 		go m.UpdateTasks()
 		go m.DoHealthChecks()
+		go m.ProcessJobs()
+		go m.ProcessCronJobs()
+		go m.ProcessWorkflows()
+		go m.ProcessServices()
 		// go m.UpdateNodeStats()
 
 		log.Printf("Starting manager API on http://%s:%d", host, port)
@@ -0,0 +1,260 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"cube/manager"
+	"cube/task"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// rolloutCmd represents the rollout command
+var rolloutCmd = &cobra.Command{
+	Use:   "rollout",
+	Short: "Manage rollouts of services.",
+	Long: `cube rollout command.
+
+When a service's template changes, the manager replaces its tasks a few at a
+time, never running more than MaxSurge extra tasks or having more than
+MaxUnavailable too few ready. A rollout pauses itself if a new task fails.
+
+A canary strategy first runs a few new tasks next to the old ones, and a
+blue/green strategy a full new set. Either waits to be promoted, by hand or
+automatically once the new tasks are ready, before replacing the old tasks.`,
+}
+
+// rolloutStatusCmd represents the rollout status command
+var rolloutStatusCmd = &cobra.Command{
+	Use:   "status <service>",
+	Short: "Show the progress of a service's rollout.",
+	Long: `cube rollout status command.
+
+The status command shows which revision a service is rolling out and how
+many of its tasks have been replaced.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		url := fmt.Sprintf("http://%s/services/%s", manager, args[0])
+
+		resp, err := http.Get(url)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Error getting service: %s", errorMessage(resp))
+		}
+
+		var s task.Service
+		err = json.NewDecoder(resp.Body).Decode(&s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printRollout(&s)
+	},
+}
+
+// rolloutPauseCmd represents the rollout pause command
+var rolloutPauseCmd = &cobra.Command{
+	Use:   "pause <service>",
+	Short: "Pause a service's rollout.",
+	Long: `cube rollout pause command.
+
+The pause command stops a rollout where it is, leaving tasks of both the old
+and new revisions running.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		s := postRollout(manager, args[0], "pause", nil)
+		log.Printf("Paused rollout of service %s at revision %d.", s.Name, s.Revision)
+	},
+}
+
+// rolloutResumeCmd represents the rollout resume command
+var rolloutResumeCmd = &cobra.Command{
+	Use:   "resume <service>",
+	Short: "Resume a paused rollout.",
+	Long: `cube rollout resume command.
+
+The resume command carries on with a rollout that was paused by hand or
+because a new task failed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		s := postRollout(manager, args[0], "resume", nil)
+		log.Printf("Resumed rollout of service %s at revision %d.", s.Name, s.Revision)
+	},
+}
+
+// rolloutPromoteCmd represents the rollout promote command
+var rolloutPromoteCmd = &cobra.Command{
+	Use:   "promote <service>",
+	Short: "Promote a canary or blue/green rollout.",
+	Long: `cube rollout promote command.
+
+The promote command approves a canary or blue/green rollout. Once its new
+tasks are ready, a canary goes on to replace the rest of the old tasks, and a
+blue/green rollout switches traffic to the new tasks and stops the old ones.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		s := postRollout(manager, args[0], "promote", nil)
+		log.Printf("Promoted revision %d of service %s.", s.Revision, s.Name)
+	},
+}
+
+// rolloutHistoryCmd represents the rollout history command
+var rolloutHistoryCmd = &cobra.Command{
+	Use:   "history <service>",
+	Short: "List the revisions of a service.",
+	Long: `cube rollout history command.
+
+The history command lists every revision of a service's template, with who
+submitted it, when, and why it was made.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+
+		resp, err := http.Get(fmt.Sprintf("http://%s/services/%s", manager, args[0]))
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Error getting service: %s", errorMessage(resp))
+		}
+		var s task.Service
+		err = json.NewDecoder(resp.Body).Decode(&s)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		resp, err = http.Get(fmt.Sprintf("http://%s/services/%s/revisions", manager, args[0]))
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Error getting revisions: %s", errorMessage(resp))
+		}
+		var revisions []*task.ServiceRevision
+		err = json.NewDecoder(resp.Body).Decode(&revisions)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "REVISION\tSUBMITTER\tCREATED\tIMAGE\tCAUSE\t")
+		for _, r := range revisions {
+			revision := strconv.Itoa(r.Revision)
+			if r.Revision == s.Revision {
+				revision += " (current)"
+			}
+			created := r.Timestamp.Local().Format(time.DateTime)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", revision, r.Submitter, created, r.Template.Image, r.Cause)
+		}
+		w.Flush()
+	},
+}
+
+// rolloutUndoCmd represents the rollout undo command
+var rolloutUndoCmd = &cobra.Command{
+	Use:   "undo <service>",
+	Short: "Roll a service back to an earlier revision.",
+	Long: `cube rollout undo command.
+
+The undo command redeploys the template of an earlier revision, by default
+the one before the current revision. The old template is rolled out as a new
+revision, like any other update.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		m, _ := cmd.Flags().GetString("manager")
+		toRevision, _ := cmd.Flags().GetInt("to-revision")
+		if toRevision < 0 {
+			log.Fatalf("Invalid revision %d", toRevision)
+		}
+
+		data, _ := json.Marshal(manager.UndoRequest{ToRevision: toRevision})
+		s := postRollout(m, args[0], "undo", data)
+		log.Printf("Rolled back service %s, now at revision %d.", s.Name, s.Revision)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rolloutCmd)
+	rolloutCmd.AddCommand(rolloutStatusCmd, rolloutPauseCmd, rolloutResumeCmd, rolloutPromoteCmd, rolloutHistoryCmd, rolloutUndoCmd)
+
+	rolloutCmd.PersistentFlags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	rolloutUndoCmd.Flags().Int("to-revision", 0, "Revision to roll back to (default the one before the current revision)")
+}
+
+// postRollout sends a rollout action to the manager and returns the
+// service as it then is.
+func postRollout(manager string, service string, action string, body []byte) *task.Service {
+	url := fmt.Sprintf("http://%s/services/%s/rollout/%s", manager, service, action)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		log.Fatalf("Error creating request %v: %v", url, err)
+	}
+	setSubmitter(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("Error connecting to %v: %v", manager, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Error sending %s: %s", action, errorMessage(resp))
+	}
+
+	var s task.Service
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	if err != nil {
+		log.Fatalf("Error decoding response: %v", err)
+	}
+
+	return &s
+}
+
+func printRollout(s *task.Service) {
+	r := s.Rollout
+	state := "progressing"
+	switch {
+	case r.Paused:
+		state = "paused"
+	case r.Complete:
+		state = "complete"
+	}
+	surge, unavailable := s.Strategy.Limits()
+	strategy := fmt.Sprintf("rolling update, max surge %d, max unavailable %d", surge, unavailable)
+	promotion := "by hand"
+	if s.Strategy.AutoPromote {
+		promotion = fmt.Sprintf("automatic after %ds", s.Strategy.PromoteDelay)
+	}
+	switch s.Strategy.Type {
+	case task.Canary:
+		strategy = fmt.Sprintf("canary of %d tasks, promoted %s, then %s", s.Canaries(), promotion, strategy)
+	case task.BlueGreen:
+		strategy = fmt.Sprintf("blue/green, promoted %s; revision %d is active", promotion, s.ActiveRevision)
+	}
+
+	fmt.Printf("Service %s, revision %d: rollout %s\n", s.Name, s.Revision, state)
+	fmt.Printf("Strategy: %s\n", strategy)
+	if s.Strategy.Type == task.Canary || s.Strategy.Type == task.BlueGreen {
+		fmt.Printf("Promoted: %t\n", r.Promoted)
+	}
+	fmt.Printf("Replicas: %d desired, %d updated, %d updated and ready, %d outdated\n", s.Replicas, r.Updated, r.UpdatedReady, r.Outdated)
+	if r.Message != "" {
+		fmt.Printf("Message: %s\n", r.Message)
+	}
+}
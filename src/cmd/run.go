@@ -5,6 +5,8 @@ package cmd
 
 import (
 	"bytes"
+	"cube/task"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -46,6 +48,15 @@ The run command starts a new task.`,
 		}
 		log.Printf("Data: %v\n", string(data))
 
+		// Catch misspelled fields, such as "Environment" instead of "Env",
+		// before the manager rejects the spec.
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		var te task.TaskEvent
+		if err := d.Decode(&te); err != nil {
+			log.Fatalf("Invalid task specification in %s: %v", filename, err)
+		}
+
 		url := fmt.Sprintf("http://%s/tasks", manager)
 		resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
 		if err != nil {
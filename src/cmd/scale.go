@@ -0,0 +1,52 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"cube/manager"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// scaleCmd represents the scale command
+var scaleCmd = &cobra.Command{
+	Use:   "scale <service> <replicas>",
+	Short: "Change how many replicas of a service run.",
+	Long: `cube scale command.
+
+The scale command sets a service's replica count. The manager then starts or
+stops tasks until that many are running.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		m, _ := cmd.Flags().GetString("manager")
+		replicas, err := strconv.Atoi(args[1])
+		if err != nil || replicas < 0 {
+			log.Fatalf("Invalid replica count %q", args[1])
+		}
+
+		data, _ := json.Marshal(manager.ScaleRequest{Replicas: replicas})
+		url := fmt.Sprintf("http://%s/services/%s/scale", m, args[0])
+		resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", m, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Error scaling service: %s", errorMessage(resp))
+		}
+
+		log.Printf("Service %s scaled to %d replicas.", args[0], replicas)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scaleCmd)
+	scaleCmd.Flags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+}
@@ -0,0 +1,246 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"cube/manager"
+	"cube/task"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/user"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// serviceCmd represents the service command
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Create and inspect services.",
+	Long: `cube service command.
+
+A service keeps a number of replicas of a task running. The manager starts
+new tasks when there are too few, including to replace ones that stopped,
+and stops tasks when there are too many.`,
+}
+
+// serviceCreateCmd represents the service create command
+var serviceCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a new service.",
+	Long: `cube service create command.
+
+The create command sends a service specification to the manager, which starts
+its replicas.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		filename, _ := cmd.Flags().GetString("filename")
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			log.Fatalf("Unable to read file: %v", filename)
+		}
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		var s task.Service
+		if err := d.Decode(&s); err != nil {
+			log.Fatalf("Invalid service specification in %s: %v", filename, err)
+		}
+
+		url := fmt.Sprintf("http://%s/services", manager)
+		req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+		if err != nil {
+			log.Fatalf("Error creating request %v: %v", url, err)
+		}
+		setSubmitter(req)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			log.Fatalf("Error creating service: %s", errorMessage(resp))
+		}
+
+		log.Printf("Created service %s with %d replicas\n", s.Name, s.Replicas)
+	},
+}
+
+// serviceUpdateCmd represents the service update command
+var serviceUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Update a service.",
+	Long: `cube service update command.
+
+The update command sends a changed service specification to the manager. If
+the template changed, the manager rolls the service's tasks over to the new
+revision a few at a time; follow it with cube rollout status.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		filename, _ := cmd.Flags().GetString("filename")
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			log.Fatalf("Unable to read file: %v", filename)
+		}
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		var s task.Service
+		if err := d.Decode(&s); err != nil {
+			log.Fatalf("Invalid service specification in %s: %v", filename, err)
+		}
+
+		url := fmt.Sprintf("http://%s/services/%s", manager, s.Name)
+		req, err := http.NewRequest("PUT", url, bytes.NewReader(data))
+		if err != nil {
+			log.Fatalf("Error creating request %v: %v", url, err)
+		}
+		setSubmitter(req)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Error updating service: %s", errorMessage(resp))
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&s)
+		if err != nil {
+			log.Fatalf("Error decoding response: %v", err)
+		}
+		log.Printf("Updated service %s, now at revision %d\n", s.Name, s.Revision)
+	},
+}
+
+// serviceListCmd represents the service list command
+var serviceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List services.",
+	Long: `cube service list command.
+
+The list command shows how many replicas each service should have, and how
+many are running and ready.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		url := fmt.Sprintf("http://%s/services", manager)
+
+		resp, err := http.Get(url)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Error listing services: %s", errorMessage(resp))
+		}
+
+		var services []*task.Service
+		err = json.NewDecoder(resp.Body).Decode(&services)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "NAME\tREPLICAS\tRUNNING\tREADY\tREVISION\tIMAGE\tAGE\tMESSAGE\t")
+		for _, s := range services {
+			age := time.Since(s.CreationTime).Round(time.Second).String()
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t\n", s.Name, s.Replicas, s.Running, s.Ready, s.Revision, s.Template.Image, age, s.Message)
+		}
+		w.Flush()
+	},
+}
+
+// serviceEndpointsCmd represents the service endpoints command
+var serviceEndpointsCmd = &cobra.Command{
+	Use:   "endpoints <service>",
+	Short: "List where a service's traffic is sent.",
+	Long: `cube service endpoints command.
+
+The endpoints command lists the ready tasks of a service that should be sent
+traffic, with the version each runs and its address. During a blue/green
+rollout only the active revision's tasks are listed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		url := fmt.Sprintf("http://%s/services/%s/endpoints", manager, args[0])
+
+		resp, err := http.Get(url)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Error getting endpoints: %s", errorMessage(resp))
+		}
+
+		var endpoints []task.Endpoint
+		err = json.NewDecoder(resp.Body).Decode(&endpoints)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "TASK\tNAME\tVERSION\tADDRESS\t")
+		for _, e := range endpoints {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", e.TaskID, e.Name, e.Version, e.Address)
+		}
+		w.Flush()
+	},
+}
+
+// serviceDeleteCmd represents the service delete command
+var serviceDeleteCmd = &cobra.Command{
+	Use:   "delete <service>",
+	Short: "Delete a service.",
+	Long: `cube service delete command.
+
+The delete command removes a service and stops all of its tasks.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		url := fmt.Sprintf("http://%s/services/%s", manager, args[0])
+
+		req, err := http.NewRequest("DELETE", url, nil)
+		if err != nil {
+			log.Fatalf("Error creating request %v: %v", url, err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			log.Fatalf("Error deleting service: %s", errorMessage(resp))
+		}
+
+		log.Printf("Service %s has been deleted.", args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serviceCmd)
+	serviceCmd.AddCommand(serviceCreateCmd, serviceUpdateCmd, serviceListCmd, serviceEndpointsCmd, serviceDeleteCmd)
+
+	serviceCmd.PersistentFlags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	serviceCreateCmd.Flags().StringP("filename", "f", "service.json", "Service specification file")
+	serviceUpdateCmd.Flags().StringP("filename", "f", "service.json", "Service specification file")
+}
+
+// setSubmitter marks a request that changes a service with who sent it, to
+// be recorded in the service's revision history.
+func setSubmitter(req *http.Request) {
+	req.Header.Set("Content-Type", "application/json")
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if name != "" {
+		req.Header.Set(manager.SubmitterHeader, name)
+	}
+}
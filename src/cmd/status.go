@@ -11,6 +11,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"text/tabwriter"
 	"time"
 
@@ -45,17 +46,15 @@ The status command allows a user to get the status of tasks from the Cube manage
 		}
 
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
-		fmt.Fprintln(w, "ID\tNAME\tCREATED\tSTATE\tCONTAINERNAME\tIMAGE\t")
-
-		for _, task := range tasks {
-			var start string
-			if task.StartTime.IsZero() {
-				start = "0 seconds ago"
-			} else {
-				start = fmt.Sprintf("%.2f ago", time.Since(task.StartTime).Seconds())
+		fmt.Fprintln(w, "ID\tNAME\tVERSION\tCREATED\tSTATE\tREADY\tRESTARTS\tEXIT\tREASON\tCONTAINERNAME\tIMAGE\t")
+
+		for _, t := range tasks {
+			printTaskRow(w, t, t.Name, t.Name)
+			for _, m := range t.Members {
+				// Members run the version of their group.
+				m.Version = t.Version
+				printTaskRow(w, &m.Task, t.Name+"/"+m.Name, t.Name+"-"+m.Name)
 			}
-			state := task.State.String()
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n", task.ID, task.Name, start, state, task.Name, task.Image)
 		}
 
 		w.Flush()
@@ -77,3 +76,43 @@ func init() {
 	// is called directly, e.g.:
 	// statusCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
+
+// printTaskRow writes one row of the status table. Members of a task group
+// are listed under the group as group/member.
+func printTaskRow(w io.Writer, t *task.Task, name string, containerName string) {
+	var start string
+	if t.StartTime.IsZero() {
+		start = "0 seconds ago"
+	} else {
+		start = fmt.Sprintf("%.2f ago", time.Since(t.StartTime).Seconds())
+	}
+	state := t.State.String()
+	ready := "-"
+	if t.State == task.Running {
+		ready = fmt.Sprintf("%v", t.Ready())
+	}
+	restarts := fmt.Sprintf("%d", t.RestartCount)
+	if !t.NextRestart.IsZero() {
+		restarts += fmt.Sprintf(" (next in %s)", time.Until(t.NextRestart).Round(time.Second))
+	}
+	exit, reason := "-", "-"
+	if !t.FinishTime.IsZero() {
+		exit = fmt.Sprintf("%d", t.ExitCode)
+	}
+	if t.TerminationMessage != "" {
+		reason = t.TerminationMessage
+	}
+	if t.Reason != "" && !strings.EqualFold(t.Reason, reason) {
+		reason = t.Reason + ": " + reason
+	}
+	if t.State == task.Running && !t.Liveness.Passing && t.Liveness.Failures > 0 {
+		reason = "liveness probe failing: " + t.Liveness.Message
+	} else if t.State == task.Running && !t.Ready() && t.Readiness.Message != "" {
+		reason = "readiness probe failing: " + t.Readiness.Message
+	}
+	version := "-"
+	if t.Version != "" {
+		version = t.Version
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", t.ID, name, version, start, state, ready, restarts, exit, reason, containerName, t.Image)
+}
@@ -4,11 +4,13 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"cube/task"
 	"cube/worker"
 	"fmt"
 	"log"
+	"os"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 )
 
@@ -23,15 +25,81 @@ The worker runs tasks and responds to the manager's requests about task state.`,
 		port, _ := cmd.Flags().GetInt("port")
 		name, _ := cmd.Flags().GetString("name")
 		dbType, _ := cmd.Flags().GetString("dbtype")
+		configFile, _ := cmd.Flags().GetString("config")
+
+		config := &worker.Config{}
+		if configFile != "" {
+			var err error
+			config, err = worker.LoadConfig(configFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		setFromFlag(cmd, "runtime", &config.Runtime)
+		setFromFlag(cmd, "runtime-endpoint", &config.RuntimeEndpoint)
+		setFromFlag(cmd, "orphan-policy", &config.OrphanPolicy)
+		setFromFlag(cmd, "registry-credentials", &config.RegistryCredentials)
+		setFromFlag(cmd, "image-ttl", &config.ImageTTL)
+		if config.ImageGCThreshold == 0 || cmd.Flags().Changed("image-gc-threshold") {
+			config.ImageGCThreshold, _ = cmd.Flags().GetFloat64("image-gc-threshold")
+		}
+		if cmd.Flags().Changed("allow-privileged") {
+			config.AllowPrivileged, _ = cmd.Flags().GetBool("allow-privileged")
+		}
+		imageTTL, err := time.ParseDuration(config.ImageTTL)
+		if err != nil {
+			log.Fatalf("invalid image TTL %q: %v", config.ImageTTL, err)
+		}
+
+		switch config.OrphanPolicy {
+		case worker.OrphanRemove, worker.OrphanKeep:
+		default:
+			log.Fatalf("unknown orphan policy %q", config.OrphanPolicy)
+		}
+
+		// Reconcile adopts containers by the worker name in their labels,
+		// so the default must be the same on every start.
+		if name == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				log.Fatalf("error getting hostname, use --name: %v", err)
+			}
+			name = fmt.Sprintf("worker-%s-%d", hostname, port)
+		}
 
 		log.Println("Starting worker.")
 
-		w := worker.New(name, dbType)
+		opts := task.RuntimeOptions{Endpoint: config.RuntimeEndpoint}
+		if config.RegistryCredentials != "" {
+			creds, err := task.LoadCredentials(config.RegistryCredentials)
+			if err != nil {
+				log.Fatal(err)
+			}
+			opts.Credentials = creds
+		}
+
+		runtime, err := task.NewRuntime(config.Runtime, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := worker.New(name, dbType, runtime)
+		w.OrphanPolicy = config.OrphanPolicy
+		w.ImageTTL = imageTTL
+		w.ImageGCThreshold = config.ImageGCThreshold
+		w.AllowPrivileged = config.AllowPrivileged
+		err = w.Reconcile()
+		if err != nil {
+			log.Printf("Error reconciling containers: %v\n", err)
+		}
+
 		api := worker.Api{Address: host, Port: port, Worker: w}
 
 		go w.RunTasks()
 		go w.CollectStats()
 		go w.UpdateTasks()
+		go w.CollectImages()
+		go w.RunProbes()
 
 		log.Printf("Starting worker API on http://%s:%d", host, port)
 		api.Start()
@@ -43,8 +111,16 @@ func init() {
 
 	workerCmd.Flags().StringP("host", "H", "0.0.0.0", "Hostname or IP address")
 	workerCmd.Flags().IntP("port", "p", 5556, "Port on which to listen")
-	workerCmd.Flags().StringP("name", "n", fmt.Sprintf("worker-%s", uuid.New().String()), "Name of the worker")
+	workerCmd.Flags().StringP("name", "n", "", "Name of the worker; must not change across restarts (defaults to worker-<hostname>-<port>)")
 	workerCmd.Flags().StringP("dbtype", "d", "memory", "Type of datastore to use for tasks (\"memory\" or \"persistent\")")
+	workerCmd.Flags().StringP("runtime", "r", "podman", "Container runtime used to run tasks (\"podman\", \"process\" or \"fake\")")
+	workerCmd.Flags().String("runtime-endpoint", "", "URI of the runtime API (defaults to the Podman socket in $XDG_RUNTIME_DIR, then /run/podman/podman.sock)")
+	workerCmd.Flags().StringP("config", "c", "", "JSON file with worker settings; flags override it")
+	workerCmd.Flags().String("registry-credentials", "", "JSON file mapping registry hosts to the Username and Password used to pull from them")
+	workerCmd.Flags().String("image-ttl", "24h", "How long an image may go unused before it can be removed")
+	workerCmd.Flags().Float64("image-gc-threshold", 85, "Disk usage percentage above which unused images are removed (0 disables)")
+	workerCmd.Flags().Bool("allow-privileged", false, "Allow tasks to run privileged containers")
+	workerCmd.Flags().String("orphan-policy", "remove", "What to do with containers at startup that belong to no known task (\"remove\" or \"keep\")")
 
 	// Here you will define your flags and configuration settings.
 
@@ -56,3 +132,12 @@ func init() {
 	// is called directly, e.g.:
 	// workerCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
+
+// setFromFlag copies the named flag into value unless value was already set
+// from the config file and the flag was not given explicitly.
+func setFromFlag(cmd *cobra.Command, name string, value *string) {
+	if *value != "" && !cmd.Flags().Changed(name) {
+		return
+	}
+	*value, _ = cmd.Flags().GetString(name)
+}
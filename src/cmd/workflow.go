@@ -0,0 +1,235 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bytes"
+	"cube/task"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+// workflowCmd represents the workflow command
+var workflowCmd = &cobra.Command{
+	Use:   "workflow",
+	Short: "Run and inspect workflows.",
+	Long: `cube workflow command.
+
+A workflow is a set of steps, each a task, where a step starts once the steps
+it depends on have succeeded. A step passes values to the steps after it by
+printing lines such as "CUBE_OUTPUT rows=1024", which they see as the
+environment variable EXTRACT_ROWS for a step named extract.`,
+}
+
+// workflowRunCmd represents the workflow run command
+var workflowRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run a new workflow.",
+	Long: `cube workflow run command.
+
+The run command sends a workflow specification to the manager, which starts
+the steps that depend on no others.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		filename, _ := cmd.Flags().GetString("filename")
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			log.Fatalf("Unable to read file: %v", filename)
+		}
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		var wf task.Workflow
+		if err := d.Decode(&wf); err != nil {
+			log.Fatalf("Invalid workflow specification in %s: %v", filename, err)
+		}
+
+		url := fmt.Sprintf("http://%s/workflows", manager)
+		resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			log.Fatalf("Error starting workflow: %s", errorMessage(resp))
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&wf)
+		if err != nil {
+			log.Fatalf("Error decoding response: %v", err)
+		}
+		log.Printf("Started workflow %s (%v)\n", wf.Name, wf.ID)
+	},
+}
+
+// workflowListCmd represents the workflow list command
+var workflowListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List workflows.",
+	Long: `cube workflow list command.
+
+The list command shows each workflow's state and how many of its steps have
+succeeded.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		url := fmt.Sprintf("http://%s/workflows", manager)
+
+		resp, err := http.Get(url)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Error listing workflows: %s", errorMessage(resp))
+		}
+
+		var workflows []*task.Workflow
+		err = json.NewDecoder(resp.Body).Decode(&workflows)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "ID\tNAME\tSTATE\tSTEPS\tAGE\tMESSAGE\t")
+		for _, wf := range workflows {
+			succeeded := 0
+			for _, s := range wf.Steps {
+				if s.State == task.StepSucceeded {
+					succeeded++
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\t%s\t%s\t\n", wf.ID, wf.Name, wf.State, succeeded, len(wf.Steps), workflowAge(wf), wf.Message)
+		}
+		w.Flush()
+	},
+}
+
+// workflowStatusCmd represents the workflow status command
+var workflowStatusCmd = &cobra.Command{
+	Use:   "status <workflowID>",
+	Short: "Show the state of a workflow's steps.",
+	Long: `cube workflow status command.
+
+The status command shows a workflow's state, then each of its steps with what
+it depends on, how many times it has run and the outputs it passed on.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		url := fmt.Sprintf("http://%s/workflows/%s", manager, args[0])
+
+		resp, err := http.Get(url)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Error getting workflow: %s", errorMessage(resp))
+		}
+
+		var wf task.Workflow
+		err = json.NewDecoder(resp.Body).Decode(&wf)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("Workflow %s (%v): %s, failure policy %s, age %s\n", wf.Name, wf.ID, wf.State, wf.FailurePolicy, workflowAge(&wf))
+		if wf.Message != "" {
+			fmt.Println(wf.Message)
+		}
+		fmt.Println()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 5, ' ', tabwriter.TabIndent)
+		fmt.Fprintln(w, "STEP\tSTATE\tDEPENDS ON\tATTEMPTS\tTASK\tOUTPUTS\tMESSAGE\t")
+		for _, s := range wf.Steps {
+			deps := "-"
+			if len(s.DependsOn) > 0 {
+				deps = strings.Join(s.DependsOn, ",")
+			}
+			taskID := "-"
+			if s.TaskID != uuid.Nil {
+				taskID = s.TaskID.String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\t%s\t%s\t%s\t\n", s.Name, s.State, deps, s.Attempts, s.Retries+1, taskID, formatOutputs(s.Outputs), s.Message)
+		}
+		w.Flush()
+	},
+}
+
+// workflowStopCmd represents the workflow stop command
+var workflowStopCmd = &cobra.Command{
+	Use:   "stop <workflowID>",
+	Short: "Stop a running workflow.",
+	Long: `cube workflow stop command.
+
+The stop command fails a workflow that has not finished, stops its running
+steps and skips the rest.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manager, _ := cmd.Flags().GetString("manager")
+		url := fmt.Sprintf("http://%s/workflows/%s", manager, args[0])
+
+		req, err := http.NewRequest("DELETE", url, nil)
+		if err != nil {
+			log.Fatalf("Error creating request %v: %v", url, err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Fatalf("Error connecting to %v: %v", manager, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			log.Fatalf("Error stopping workflow: %s", errorMessage(resp))
+		}
+
+		log.Printf("Workflow %v has been stopped.", args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(workflowCmd)
+	workflowCmd.AddCommand(workflowRunCmd, workflowListCmd, workflowStatusCmd, workflowStopCmd)
+
+	workflowCmd.PersistentFlags().StringP("manager", "m", "localhost:5555", "Manager to talk to")
+	workflowRunCmd.Flags().StringP("filename", "f", "workflow.json", "Workflow specification file")
+}
+
+func workflowAge(wf *task.Workflow) string {
+	if wf.StartTime.IsZero() {
+		return "-"
+	}
+	end := time.Now()
+	if wf.Finished() {
+		end = wf.CompletionTime
+	}
+
+	return end.Sub(wf.StartTime).Round(time.Second).String()
+}
+
+// formatOutputs lists outputs as key=value pairs in key order.
+func formatOutputs(outputs map[string]string) string {
+	if len(outputs) == 0 {
+		return "-"
+	}
+	keys := make([]string, 0, len(outputs))
+	for k := range outputs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + outputs[k]
+	}
+
+	return strings.Join(pairs, ",")
+}
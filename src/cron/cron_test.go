@@ -0,0 +1,157 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{"* * * * *", false},
+		{"*/15 0-6,22 1 jan-mar mon-fri", false},
+		{"5/15 * * * *", false},
+		{"0 0 * * 7", false},
+		{"@daily", false},
+		{"@HOURLY", false},
+		{"* * * *", true},
+		{"* * * * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"*/0 * * * *", true},
+		{"5-1 * * * *", true},
+		{"* * * foo *", true},
+		{"@every", true},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	ny := func(s string) time.Time {
+		return utc(s).In(newYork)
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		// want lists the next few times the schedule fires, in order
+		want []time.Time
+	}{
+		{
+			name: "step",
+			spec: "*/15 * * * *",
+			from: utc("2026-10-17T10:07:30Z"),
+			want: []time.Time{utc("2026-10-17T10:15:00Z"), utc("2026-10-17T10:30:00Z")},
+		},
+		{
+			name: "exact time is not repeated",
+			spec: "*/15 * * * *",
+			from: utc("2026-10-17T10:15:00Z"),
+			want: []time.Time{utc("2026-10-17T10:30:00Z")},
+		},
+		{
+			name: "weekdays skip the weekend",
+			spec: "0 9 * * mon-fri",
+			from: utc("2026-10-16T09:00:00Z"),
+			want: []time.Time{utc("2026-10-19T09:00:00Z"), utc("2026-10-20T09:00:00Z")},
+		},
+		{
+			name: "sunday as 7",
+			spec: "0 0 * * 7",
+			from: utc("2026-10-17T00:00:00Z"),
+			want: []time.Time{utc("2026-10-18T00:00:00Z"), utc("2026-10-25T00:00:00Z")},
+		},
+		{
+			name: "day of month or day of week",
+			spec: "0 0 1 * mon",
+			from: utc("2026-10-27T00:00:00Z"),
+			want: []time.Time{utc("2026-11-01T00:00:00Z"), utc("2026-11-02T00:00:00Z"), utc("2026-11-09T00:00:00Z")},
+		},
+		{
+			name: "leap day",
+			spec: "0 0 29 2 *",
+			from: utc("2026-10-17T00:00:00Z"),
+			want: []time.Time{utc("2028-02-29T00:00:00Z")},
+		},
+		{
+			name: "never",
+			spec: "0 0 30 2 *",
+			from: utc("2026-10-17T00:00:00Z"),
+			want: []time.Time{{}},
+		},
+		{
+			name: "time of day when clocks go back fires once",
+			spec: "30 1 * * *",
+			from: ny("2026-11-01T04:00:00Z"),
+			want: []time.Time{ny("2026-11-01T05:30:00Z"), ny("2026-11-02T06:30:00Z")},
+		},
+		{
+			name: "every half hour when clocks go back keeps going",
+			spec: "*/30 * * * *",
+			from: ny("2026-11-01T04:45:00Z"),
+			want: []time.Time{
+				ny("2026-11-01T05:00:00Z"),
+				ny("2026-11-01T05:30:00Z"),
+				ny("2026-11-01T06:00:00Z"),
+				ny("2026-11-01T06:30:00Z"),
+				ny("2026-11-01T07:00:00Z"),
+			},
+		},
+		{
+			name: "time of day skipped when clocks go forward",
+			spec: "30 2 * * *",
+			from: ny("2026-03-08T05:00:00Z"),
+			want: []time.Time{ny("2026-03-09T06:30:00Z")},
+		},
+		{
+			name: "hour after clocks go forward",
+			spec: "0 3 * * *",
+			from: ny("2026-03-08T05:00:00Z"),
+			want: []time.Time{ny("2026-03-08T07:00:00Z")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.spec, err)
+			}
+
+			from := tt.from
+			for i, want := range tt.want {
+				got := s.Next(from)
+				if !got.Equal(want) {
+					t.Fatalf("Next #%d after %v = %v, want %v", i+1, from, got, want)
+				}
+				if !got.IsZero() && got.Location() != from.Location() {
+					t.Errorf("Next #%d is in %v, want %v", i+1, got.Location(), from.Location())
+				}
+				from = got
+			}
+		})
+	}
+}
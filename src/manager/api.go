@@ -26,9 +26,54 @@ func (a *Api) initRouter() {
 		r.Get("/", a.GetTasksHandler)
 		r.Route("/{taskID}", func(r chi.Router) {
 			r.Delete("/", a.StopTaskHandler)
+			r.Get("/logs", a.GetTaskLogsHandler)
+			r.Post("/exec", a.ExecTaskHandler)
+		})
+	})
+	a.Router.Route("/jobs", func(r chi.Router) {
+		r.Post("/", a.StartJobHandler)
+		r.Get("/", a.GetJobsHandler)
+		r.Route("/{jobID}", func(r chi.Router) {
+			r.Get("/", a.GetJobHandler)
+			r.Delete("/", a.StopJobHandler)
+		})
+	})
+	a.Router.Route("/cronjobs", func(r chi.Router) {
+		r.Post("/", a.AddCronJobHandler)
+		r.Get("/", a.GetCronJobsHandler)
+		r.Route("/{cronJobID}", func(r chi.Router) {
+			r.Get("/", a.GetCronJobHandler)
+			r.Delete("/", a.DeleteCronJobHandler)
+		})
+	})
+	a.Router.Route("/workflows", func(r chi.Router) {
+		r.Post("/", a.StartWorkflowHandler)
+		r.Get("/", a.GetWorkflowsHandler)
+		r.Route("/{workflowID}", func(r chi.Router) {
+			r.Get("/", a.GetWorkflowHandler)
+			r.Delete("/", a.StopWorkflowHandler)
+		})
+	})
+	a.Router.Route("/services", func(r chi.Router) {
+		r.Post("/", a.AddServiceHandler)
+		r.Get("/", a.GetServicesHandler)
+		r.Route("/{serviceName}", func(r chi.Router) {
+			r.Get("/", a.GetServiceHandler)
+			r.Put("/", a.UpdateServiceHandler)
+			r.Delete("/", a.DeleteServiceHandler)
+			r.Post("/scale", a.ScaleServiceHandler)
+			r.Post("/rollout/pause", a.PauseRolloutHandler)
+			r.Post("/rollout/resume", a.ResumeRolloutHandler)
+			r.Post("/rollout/undo", a.UndoRolloutHandler)
+			r.Post("/rollout/promote", a.PromoteRolloutHandler)
+			r.Get("/revisions", a.GetRevisionsHandler)
+			r.Get("/endpoints", a.GetEndpointsHandler)
 		})
 	})
 	a.Router.Route("/nodes", func(r chi.Router) {
 		r.Get("/", a.GetNodesHandler)
 	})
+	a.Router.Route("/images", func(r chi.Router) {
+		r.Post("/", a.PullImagesHandler)
+	})
 }
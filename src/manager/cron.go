@@ -0,0 +1,244 @@
+package manager
+
+import (
+	"cube/task"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AddCronJob stores a new cron job. Its first run is the first time its
+// schedule fires after now.
+func (m *Manager) AddCronJob(c task.CronJob) (*task.CronJob, error) {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	err := c.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.CronDb.Get(c.ID.String()); err == nil {
+		return nil, fmt.Errorf("cron job %v already exists", c.ID)
+	}
+
+	c.CreationTime = time.Now().UTC()
+	c.LastScheduleTime = time.Time{}
+	c.NextScheduleTime, _ = c.NextRun(c.CreationTime)
+	c.Runs = nil
+	err = m.CronDb.Put(c.ID.String(), &c)
+	if err != nil {
+		return nil, fmt.Errorf("error storing cron job %v: %w", c.ID, err)
+	}
+
+	return &c, nil
+}
+
+func (m *Manager) GetCronJobs() []*task.CronJob {
+	cronList, err := m.CronDb.List()
+	if err != nil {
+		log.Printf("error getting list of cron jobs: %v\n", err)
+		return nil
+	}
+
+	return cronList.([]*task.CronJob)
+}
+
+func (m *Manager) GetCronJob(id uuid.UUID) (*task.CronJob, error) {
+	result, err := m.CronDb.Get(id.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*task.CronJob), nil
+}
+
+// DeleteCronJob stops a cron job from scheduling any more runs. Runs it
+// has already started are left to finish.
+func (m *Manager) DeleteCronJob(id uuid.UUID) error {
+	m.cronMu.Lock()
+	defer m.cronMu.Unlock()
+	return m.CronDb.Delete(id.String())
+}
+
+func (m *Manager) ProcessCronJobs() {
+	for {
+		log.Println("Processing cron jobs")
+		m.processCronJobs()
+		log.Println("Sleeping for 10 seconds")
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func (m *Manager) processCronJobs() {
+	for _, c := range m.GetCronJobs() {
+		m.cronMu.Lock()
+		// Sync the cron job as it is now, so one deleted since the list
+		// was read is not brought back.
+		c, err := m.GetCronJob(c.ID)
+		if err == nil {
+			m.syncCronJob(c, time.Now().UTC())
+			err = m.CronDb.Put(c.ID.String(), c)
+			if err != nil {
+				log.Printf("Error updating cron job %s in database: %v", c.ID.String(), err)
+			}
+		}
+		m.cronMu.Unlock()
+	}
+}
+
+// syncCronJob records which of the cron job's runs have finished and starts
+// a new run if one is due. When several runs were missed, only the latest
+// is started.
+func (m *Manager) syncCronJob(c *task.CronJob, now time.Time) {
+	for i := range c.Runs {
+		if !c.Runs[i].Finished {
+			c.Runs[i].Finished = m.cronRunFinished(c, c.Runs[i])
+		}
+	}
+	m.trimCronHistory(c)
+
+	last := c.LastScheduleTime
+	if last.IsZero() {
+		last = c.CreationTime
+	}
+	due, err := c.NextRun(last)
+	if err != nil {
+		log.Printf("Error scheduling cron job %s: %v\n", c.Name, err)
+		return
+	}
+	if due.IsZero() || due.After(now) {
+		c.NextScheduleTime = due
+		return
+	}
+	for {
+		next, _ := c.NextRun(due)
+		if next.IsZero() || next.After(now) {
+			c.NextScheduleTime = next
+			break
+		}
+		due = next
+	}
+	c.LastScheduleTime = due
+
+	deadline := time.Duration(c.StartingDeadline) * time.Second
+	if deadline > 0 && now.Sub(due) > deadline {
+		log.Printf("Cron job %s missed its run at %v by more than %v, skipping it\n", c.Name, due, deadline)
+		return
+	}
+
+	active := c.ActiveRuns()
+	switch c.ConcurrencyPolicy {
+	case task.ConcurrencyForbid:
+		if len(active) > 0 {
+			log.Printf("Cron job %s still has %d active runs, skipping the run at %v\n", c.Name, len(active), due)
+			return
+		}
+	case task.ConcurrencyReplace:
+		for _, r := range active {
+			log.Printf("Cron job %s is replacing run %v\n", c.Name, r.ID)
+			m.stopCronRun(c, r)
+		}
+	}
+
+	run, err := m.startCronRun(c, due)
+	if err != nil {
+		log.Printf("Error starting cron job %s: %v\n", c.Name, err)
+		return
+	}
+	c.Runs = append(c.Runs, run)
+	log.Printf("Cron job %s started run %v for %v\n", c.Name, run.ID, due)
+}
+
+// trimCronHistory deletes the tasks or jobs of the runs beyond the cron job's
+// history limit, along with their containers. Runs that could not be deleted
+// are kept and tried again next time.
+func (m *Manager) trimCronHistory(c *task.CronJob) {
+	var kept []task.CronRun
+	for _, r := range c.TrimHistory() {
+		err := m.deleteCronRun(c, r)
+		if err != nil {
+			log.Printf("Error deleting run %v of cron job %s: %v\n", r.ID, c.Name, err)
+			kept = append(kept, r)
+		}
+	}
+	c.Runs = append(kept, c.Runs...)
+}
+
+func (m *Manager) deleteCronRun(c *task.CronJob, r task.CronRun) error {
+	if c.JobTemplate == nil {
+		return m.deleteTask(r.ID)
+	}
+
+	j, err := m.GetJob(r.ID)
+	if err != nil {
+		// Already deleted.
+		return nil
+	}
+	for _, id := range j.Tasks {
+		err := m.deleteTask(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return m.JobDb.Delete(j.ID.String())
+}
+
+// startCronRun creates a task or job from the cron job's template.
+func (m *Manager) startCronRun(c *task.CronJob, due time.Time) (task.CronRun, error) {
+	name := fmt.Sprintf("%s-%d", c.Name, due.Unix())
+
+	if c.JobTemplate != nil {
+		j := *c.JobTemplate
+		j.ID = uuid.Nil
+		j.Name = name
+		job, err := m.AddJob(j)
+		if err != nil {
+			return task.CronRun{}, err
+		}
+		return task.CronRun{ID: job.ID, ScheduledTime: due}, nil
+	}
+
+	t := *c.TaskTemplate
+	t.ID = uuid.New()
+	t.Name = name
+	// A failed run stays failed; the next one is a run of its own.
+	t.RestartPolicy = task.RestartPolicy{Policy: task.RestartNever}
+	m.submitTask(t)
+	return task.CronRun{ID: t.ID, ScheduledTime: due}, nil
+}
+
+func (m *Manager) stopCronRun(c *task.CronJob, r task.CronRun) {
+	if c.JobTemplate != nil {
+		_, err := m.StopJob(r.ID)
+		if err != nil {
+			log.Printf("Error stopping job %v: %v\n", r.ID, err)
+		}
+		return
+	}
+
+	result, err := m.TaskDb.Get(r.ID.String())
+	if err != nil {
+		log.Printf("Error getting task %v: %v\n", r.ID, err)
+		return
+	}
+	m.cancelTask(result.(*task.Task), "replaced by a newer run")
+}
+
+// cronRunFinished reports whether a run's task or job has finished. A run
+// that can no longer be found counts as finished.
+func (m *Manager) cronRunFinished(c *task.CronJob, r task.CronRun) bool {
+	if c.JobTemplate != nil {
+		j, err := m.GetJob(r.ID)
+		return err != nil || j.Finished()
+	}
+
+	result, err := m.TaskDb.Get(r.ID.String())
+	if err != nil {
+		return true
+	}
+	t := result.(*task.Task)
+	return t.State == task.Completed || t.State == task.Failed
+}
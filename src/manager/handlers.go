@@ -2,10 +2,15 @@
 package manager
 
 import (
+	"cube/stream"
 	"cube/task"
+	"cube/utils"
+	"cube/worker"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"time"
 
@@ -18,6 +23,34 @@ type ErrResponse struct {
 	Message        string
 }
 
+// ScaleRequest is the body of a request to scale a service.
+type ScaleRequest struct {
+	Replicas int
+}
+
+// UndoRequest is the body of a request to roll a service back. A zero
+// ToRevision means the revision before the current one.
+type UndoRequest struct {
+	ToRevision int
+}
+
+// SubmitterHeader names who made a request that changes a service, to be
+// recorded with the revision it makes. Requests without it are recorded as
+// coming from the client's address.
+const SubmitterHeader = "X-Cube-Submitter"
+
+func submitter(r *http.Request) string {
+	if s := r.Header.Get(SubmitterHeader); s != "" {
+		return s
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
 func (a *Api) GetTasksHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
@@ -41,6 +74,11 @@ func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(e)
 		return
 	}
+	err = te.Task.Validate()
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
 
 	a.Manager.AddTask(te)
 	log.Printf("Added task %v\n", te.Task.ID)
@@ -88,3 +126,549 @@ func (a *Api) GetNodesHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(200)
 	json.NewEncoder(w).Encode(a.Manager.WorkerNodes)
 }
+
+func (a *Api) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "taskID")
+	tID, err := uuid.Parse(taskID)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid taskID %s: %v", taskID, err))
+		return
+	}
+
+	resp, err := a.Manager.GetTaskLogs(r.Context(), tID, r.URL.RawQuery)
+	if err != nil {
+		writeError(w, 404, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(utils.FlushWriter{W: w}, resp.Body)
+}
+
+func (a *Api) PullImagesHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	req := worker.ImagePullRequest{}
+	err := d.Decode(&req)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	if len(req.Images) == 0 {
+		writeError(w, 400, "No images given")
+		return
+	}
+
+	results := a.Manager.PullImages(req.Images)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(results)
+}
+
+// ExecTaskHandler relays an exec session between the client and the worker
+// running the task, once both connections have been upgraded.
+func (a *Api) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "taskID")
+	tID, err := uuid.Parse(taskID)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid taskID %s: %v", taskID, err))
+		return
+	}
+
+	if r.Header.Get("Upgrade") != stream.Protocol {
+		writeError(w, 400, fmt.Sprintf("Exec requires the %s upgrade protocol", stream.Protocol))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Error reading body: %v", err))
+		return
+	}
+
+	resp, err := a.Manager.ExecTask(tID, body)
+	if err != nil {
+		writeError(w, 404, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+	workerConn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		writeError(w, 502, "Worker connection cannot be used for exec")
+		return
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeError(w, 500, fmt.Sprintf("Error upgrading connection: %v", err))
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", stream.Protocol)
+	err = brw.Flush()
+	if err != nil {
+		log.Printf("Error upgrading connection: %v\n", err)
+		return
+	}
+
+	go func() {
+		io.Copy(workerConn, brw.Reader)
+		workerConn.Close()
+	}()
+	io.Copy(conn, workerConn)
+}
+
+func (a *Api) StartJobHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	j := task.Job{}
+	err := d.Decode(&j)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+
+	job, err := a.Manager.AddJob(j)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+
+	log.Printf("Added job %v\n", job.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(job)
+}
+
+func (a *Api) GetJobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(a.Manager.GetJobs())
+}
+
+func (a *Api) GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobID")
+	jID, err := uuid.Parse(jobID)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid jobID %s: %v", jobID, err))
+		return
+	}
+
+	job, err := a.Manager.GetJob(jID)
+	if err != nil {
+		writeError(w, 404, fmt.Sprintf("No job with ID %v found", jID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(job)
+}
+
+// StopJobHandler fails a running job and stops its tasks. The job is kept
+// so its status can still be read.
+func (a *Api) StopJobHandler(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobID")
+	jID, err := uuid.Parse(jobID)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid jobID %s: %v", jobID, err))
+		return
+	}
+
+	if _, err := a.Manager.GetJob(jID); err != nil {
+		writeError(w, 404, fmt.Sprintf("No job with ID %v found", jID))
+		return
+	}
+	_, err = a.Manager.StopJob(jID)
+	if err != nil {
+		writeError(w, 409, err.Error())
+		return
+	}
+
+	log.Printf("Stopped job %v\n", jID)
+	w.WriteHeader(204)
+}
+
+func (a *Api) AddCronJobHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	c := task.CronJob{}
+	err := d.Decode(&c)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+
+	cronJob, err := a.Manager.AddCronJob(c)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+
+	log.Printf("Added cron job %v\n", cronJob.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(cronJob)
+}
+
+func (a *Api) GetCronJobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(a.Manager.GetCronJobs())
+}
+
+func (a *Api) GetCronJobHandler(w http.ResponseWriter, r *http.Request) {
+	cronJobID := chi.URLParam(r, "cronJobID")
+	cID, err := uuid.Parse(cronJobID)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid cronJobID %s: %v", cronJobID, err))
+		return
+	}
+
+	cronJob, err := a.Manager.GetCronJob(cID)
+	if err != nil {
+		writeError(w, 404, fmt.Sprintf("No cron job with ID %v found", cID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(cronJob)
+}
+
+func (a *Api) DeleteCronJobHandler(w http.ResponseWriter, r *http.Request) {
+	cronJobID := chi.URLParam(r, "cronJobID")
+	cID, err := uuid.Parse(cronJobID)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid cronJobID %s: %v", cronJobID, err))
+		return
+	}
+
+	err = a.Manager.DeleteCronJob(cID)
+	if err != nil {
+		writeError(w, 404, fmt.Sprintf("No cron job with ID %v found", cID))
+		return
+	}
+
+	log.Printf("Deleted cron job %v\n", cID)
+	w.WriteHeader(204)
+}
+
+func (a *Api) StartWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	wf := task.Workflow{}
+	err := d.Decode(&wf)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+
+	workflow, err := a.Manager.AddWorkflow(wf)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+
+	log.Printf("Added workflow %v\n", workflow.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(workflow)
+}
+
+func (a *Api) GetWorkflowsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(a.Manager.GetWorkflows())
+}
+
+// GetWorkflowHandler returns a workflow along with the state of each of its
+// steps.
+func (a *Api) GetWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	workflowID := chi.URLParam(r, "workflowID")
+	wfID, err := uuid.Parse(workflowID)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid workflowID %s: %v", workflowID, err))
+		return
+	}
+
+	workflow, err := a.Manager.GetWorkflow(wfID)
+	if err != nil {
+		writeError(w, 404, fmt.Sprintf("No workflow with ID %v found", wfID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(workflow)
+}
+
+// StopWorkflowHandler fails a running workflow and stops its steps. The
+// workflow is kept so its status can still be read.
+func (a *Api) StopWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	workflowID := chi.URLParam(r, "workflowID")
+	wfID, err := uuid.Parse(workflowID)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid workflowID %s: %v", workflowID, err))
+		return
+	}
+
+	if _, err := a.Manager.GetWorkflow(wfID); err != nil {
+		writeError(w, 404, fmt.Sprintf("No workflow with ID %v found", wfID))
+		return
+	}
+	_, err = a.Manager.StopWorkflow(wfID)
+	if err != nil {
+		writeError(w, 409, err.Error())
+		return
+	}
+
+	log.Printf("Stopped workflow %v\n", wfID)
+	w.WriteHeader(204)
+}
+
+func (a *Api) AddServiceHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	s := task.Service{}
+	err := d.Decode(&s)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+
+	if _, err := a.Manager.GetService(s.Name); err == nil {
+		writeError(w, 409, fmt.Sprintf("Service %s already exists", s.Name))
+		return
+	}
+	service, err := a.Manager.AddService(s, submitter(r))
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+
+	log.Printf("Added service %s\n", service.Name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(service)
+}
+
+func (a *Api) GetServicesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(a.Manager.GetServices())
+}
+
+func (a *Api) GetServiceHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "serviceName")
+	service, err := a.Manager.GetService(name)
+	if err != nil {
+		writeError(w, 404, fmt.Sprintf("No service named %s found", name))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(service)
+}
+
+// UpdateServiceHandler replaces a service's spec, rolling its tasks over to
+// a new revision if the template changed.
+func (a *Api) UpdateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "serviceName")
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	s := task.Service{}
+	err := d.Decode(&s)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	if s.Name == "" {
+		s.Name = name
+	}
+	if s.Name != name {
+		writeError(w, 400, fmt.Sprintf("Service name %s does not match %s", s.Name, name))
+		return
+	}
+
+	if _, err := a.Manager.GetService(name); err != nil {
+		writeError(w, 404, fmt.Sprintf("No service named %s found", name))
+		return
+	}
+	service, err := a.Manager.UpdateService(s, submitter(r))
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+
+	log.Printf("Updated service %s\n", name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(service)
+}
+
+// DeleteServiceHandler removes a service and stops its tasks.
+func (a *Api) DeleteServiceHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "serviceName")
+	err := a.Manager.DeleteService(name)
+	if err != nil {
+		writeError(w, 404, fmt.Sprintf("No service named %s found", name))
+		return
+	}
+
+	log.Printf("Deleted service %s\n", name)
+	w.WriteHeader(204)
+}
+
+func (a *Api) ScaleServiceHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "serviceName")
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	req := ScaleRequest{}
+	err := d.Decode(&req)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+
+	if _, err := a.Manager.GetService(name); err != nil {
+		writeError(w, 404, fmt.Sprintf("No service named %s found", name))
+		return
+	}
+	service, err := a.Manager.ScaleService(name, req.Replicas)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+
+	log.Printf("Scaled service %s to %d replicas\n", name, req.Replicas)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(service)
+}
+
+func (a *Api) PauseRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "serviceName")
+	service, err := a.Manager.PauseRollout(name)
+	if err != nil {
+		writeError(w, 404, fmt.Sprintf("No service named %s found", name))
+		return
+	}
+
+	log.Printf("Paused rollout of service %s\n", name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(service)
+}
+
+func (a *Api) ResumeRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "serviceName")
+	if _, err := a.Manager.GetService(name); err != nil {
+		writeError(w, 404, fmt.Sprintf("No service named %s found", name))
+		return
+	}
+	service, err := a.Manager.ResumeRollout(name)
+	if err != nil {
+		writeError(w, 409, err.Error())
+		return
+	}
+
+	log.Printf("Resumed rollout of service %s\n", name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(service)
+}
+
+func (a *Api) GetRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "serviceName")
+	if _, err := a.Manager.GetService(name); err != nil {
+		writeError(w, 404, fmt.Sprintf("No service named %s found", name))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(a.Manager.GetRevisions(name))
+}
+
+// UndoRolloutHandler rolls a service back to an earlier revision.
+func (a *Api) UndoRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "serviceName")
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	req := UndoRequest{}
+	err := d.Decode(&req)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+
+	if _, err := a.Manager.GetService(name); err != nil {
+		writeError(w, 404, fmt.Sprintf("No service named %s found", name))
+		return
+	}
+	service, err := a.Manager.UndoService(name, req.ToRevision, submitter(r))
+	if err != nil {
+		writeError(w, 409, err.Error())
+		return
+	}
+
+	log.Printf("Rolled back service %s, now at revision %d\n", name, service.Revision)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(service)
+}
+
+func (a *Api) PromoteRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "serviceName")
+	if _, err := a.Manager.GetService(name); err != nil {
+		writeError(w, 404, fmt.Sprintf("No service named %s found", name))
+		return
+	}
+	service, err := a.Manager.PromoteRollout(name)
+	if err != nil {
+		writeError(w, 409, err.Error())
+		return
+	}
+
+	log.Printf("Promoted rollout of service %s\n", name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(service)
+}
+
+// GetEndpointsHandler returns the tasks of a service that should be sent
+// traffic, with their versions and addresses.
+func (a *Api) GetEndpointsHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "serviceName")
+	endpoints, err := a.Manager.GetEndpoints(name)
+	if err != nil {
+		writeError(w, 404, fmt.Sprintf("No service named %s found", name))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(endpoints)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	log.Print(msg)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(ErrResponse{
+		HTTPStatusCode: code,
+		Message:        msg,
+	})
+}
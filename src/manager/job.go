@@ -0,0 +1,229 @@
+package manager
+
+import (
+	"cube/task"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AddJob stores a new job for ProcessJobs to start.
+func (m *Manager) AddJob(j task.Job) (*task.Job, error) {
+	if j.ID == uuid.Nil {
+		j.ID = uuid.New()
+	}
+	j.SetDefaults()
+	err := j.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.JobDb.Get(j.ID.String()); err == nil {
+		return nil, fmt.Errorf("job %v already exists", j.ID)
+	}
+
+	j.State = task.JobPending
+	j.Tasks = nil
+	j.Active, j.Succeeded, j.Failed = 0, 0, 0
+	err = m.JobDb.Put(j.ID.String(), &j)
+	if err != nil {
+		return nil, fmt.Errorf("error storing job %v: %w", j.ID, err)
+	}
+
+	return &j, nil
+}
+
+func (m *Manager) GetJobs() []*task.Job {
+	jobList, err := m.JobDb.List()
+	if err != nil {
+		log.Printf("error getting list of jobs: %v\n", err)
+		return nil
+	}
+
+	return jobList.([]*task.Job)
+}
+
+func (m *Manager) GetJob(id uuid.UUID) (*task.Job, error) {
+	result, err := m.JobDb.Get(id.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*task.Job), nil
+}
+
+// StopJob fails a job that has not finished and stops its tasks.
+func (m *Manager) StopJob(id uuid.UUID) (*task.Job, error) {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+	j, err := m.GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if j.Finished() {
+		return nil, fmt.Errorf("job %v has already finished", id)
+	}
+
+	m.syncJob(j)
+	if !j.Finished() {
+		m.finishJob(j, task.JobFailed, "stopped")
+	}
+	err = m.JobDb.Put(j.ID.String(), j)
+	if err != nil {
+		return nil, fmt.Errorf("error updating job %v: %w", j.ID, err)
+	}
+
+	return j, nil
+}
+
+func (m *Manager) ProcessJobs() {
+	for {
+		log.Println("Processing jobs")
+		m.processJobs()
+		log.Println("Sleeping for 10 seconds")
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func (m *Manager) processJobs() {
+	for _, j := range m.GetJobs() {
+		if j.Finished() {
+			continue
+		}
+
+		m.jobMu.Lock()
+		// Sync the job as it is now, in case it was stopped since the list
+		// was read.
+		j, err := m.GetJob(j.ID)
+		if err == nil && !j.Finished() {
+			m.syncJob(j)
+			err = m.JobDb.Put(j.ID.String(), j)
+			if err != nil {
+				log.Printf("Error updating job %s in database: %v", j.ID.String(), err)
+			}
+		}
+		m.jobMu.Unlock()
+	}
+}
+
+// syncJob counts how the job's tasks have ended, then either finishes the
+// job or creates tasks until Parallelism of them are active.
+func (m *Manager) syncJob(j *task.Job) {
+	j.Active, j.Succeeded, j.Failed = 0, 0, 0
+	for _, t := range m.jobTasks(j) {
+		switch {
+		case t.State == task.Completed && t.Reason != task.ReasonStopped:
+			j.Succeeded++
+		case t.State == task.Completed || t.State == task.Failed:
+			j.Failed++
+		default:
+			j.Active++
+		}
+	}
+
+	switch {
+	case j.Succeeded >= j.Completions:
+		m.finishJob(j, task.JobSucceeded, fmt.Sprintf("%d of %d tasks succeeded", j.Succeeded, j.Completions))
+		return
+	case j.Failed > *j.BackoffLimit:
+		m.finishJob(j, task.JobFailed, fmt.Sprintf("%d tasks failed, more than the backoff limit of %d", j.Failed, *j.BackoffLimit))
+		return
+	}
+
+	if j.State == task.JobPending {
+		j.State = task.JobRunning
+		j.StartTime = time.Now().UTC()
+	}
+
+	want := min(j.Parallelism, j.Completions-j.Succeeded) - j.Active
+	for i := 0; i < want; i++ {
+		t := j.NewTask()
+		j.Tasks = append(j.Tasks, t.ID)
+		j.Active++
+		m.submitTask(t)
+		log.Printf("Job %s created task %v\n", j.Name, t.ID)
+	}
+}
+
+// submitTask queues a task the manager has created itself. The task is
+// stored as Pending straight away so it is seen while it waits to be
+// scheduled.
+func (m *Manager) submitTask(t task.Task) {
+	t.State = task.Pending
+	err := m.TaskDb.Put(t.ID.String(), &t)
+	if err != nil {
+		log.Printf("Error updating task %s in database: %v", t.ID.String(), err)
+	}
+
+	te := task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Running,
+		Timestamp: time.Now(),
+		Task:      t,
+	}
+	te.Task.State = task.Scheduled
+	m.AddTask(te)
+}
+
+// cancelTask stops a task the manager created that has not finished. A
+// worker handles requests in order, so a task it has been sent but not yet
+// started is stopped once it starts. If the worker cannot be reached, the
+// stop is queued for SendWork to retry. A task never sent to a worker is
+// just marked as stopped, and SendWork drops it.
+func (m *Manager) cancelTask(t *task.Task, msg string) {
+	if t.State != task.Pending && t.State != task.Scheduled && t.State != task.Running {
+		return
+	}
+
+	if w, ok := m.taskWorker(t.ID); ok {
+		err := m.stopTask(w, t.ID.String())
+		if err != nil {
+			log.Printf("Error stopping task %v, queueing the stop: %v\n", t.ID, err)
+			m.AddTask(task.TaskEvent{
+				ID:        uuid.New(),
+				State:     task.Completed,
+				Timestamp: time.Now(),
+				Task:      *t,
+			})
+			return
+		}
+		t.State = task.Stopping
+	} else {
+		t.State = task.Failed
+		t.Reason = task.ReasonStopped
+		t.TerminationMessage = msg
+	}
+	err := m.TaskDb.Put(t.ID.String(), t)
+	if err != nil {
+		log.Printf("Error updating task %s in database: %v", t.ID.String(), err)
+	}
+}
+
+// finishJob records how the job ended and stops any tasks it still has
+// running, which are not needed once a job has succeeded or failed.
+func (m *Manager) finishJob(j *task.Job, state task.JobState, msg string) {
+	log.Printf("Job %s %v: %s\n", j.Name, state, msg)
+	j.State = state
+	j.Message = msg
+	j.CompletionTime = time.Now().UTC()
+	j.Active = 0
+
+	for _, t := range m.jobTasks(j) {
+		m.cancelTask(t, "job finished")
+	}
+}
+
+func (m *Manager) jobTasks(j *task.Job) []*task.Task {
+	var tasks []*task.Task
+	for _, id := range j.Tasks {
+		result, err := m.TaskDb.Get(id.String())
+		if err != nil {
+			log.Printf("Error getting task %v of job %s: %v\n", id, j.Name, err)
+			continue
+		}
+		tasks = append(tasks, result.(*task.Task))
+	}
+
+	return tasks
+}
@@ -2,9 +2,11 @@ package manager
 
 import (
 	"bytes"
+	"context"
 	"cube/node"
 	"cube/scheduler"
 	"cube/store"
+	"cube/stream"
 	"cube/task"
 	"cube/worker"
 	"encoding/json"
@@ -12,24 +14,50 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"strings"
+	"sync"
 	"time"
 
-	nettypes "github.com/containers/common/libnetwork/types"
 	"github.com/golang-collections/collections/queue"
 	"github.com/google/uuid"
 )
 
 type Manager struct {
+	// Pending is shared by the API, the background loops and SendWork, so
+	// use AddTask and nextPending rather than the queue itself
 	Pending       queue.Queue
+	pendingMu     sync.Mutex
 	TaskDb        store.Store
 	EventDb       store.Store
+	JobDb         store.Store
+	CronDb        store.Store
+	WorkflowDb    store.Store
+	ServiceDb     store.Store
+	RevisionDb    store.Store
 	Workers       []string // hostname:port
 	WorkerTaskMap map[string][]uuid.UUID
 	TaskWorkerMap map[uuid.UUID]string
 	LastWorker    int
 	WorkerNodes   []*node.Node
 	Scheduler     scheduler.Scheduler
+
+	// workersMu guards WorkerTaskMap and TaskWorkerMap, which SendWork and
+	// updateTasks write while the API and the loops read them
+	workersMu sync.RWMutex
+
+	// jobMu keeps StopJob and the job loop from overwriting each other
+	jobMu sync.Mutex
+
+	// cronMu keeps DeleteCronJob and the cron loop from overwriting each
+	// other
+	cronMu sync.Mutex
+
+	// workflowMu keeps StopWorkflow and the workflow loop from overwriting
+	// each other
+	workflowMu sync.Mutex
+
+	// serviceMu keeps changes to services from the API and the reconcile
+	// loop from overwriting each other
+	serviceMu sync.Mutex
 }
 
 func New(workers []string, schedulerType string, dbType string) *Manager {
@@ -63,17 +91,32 @@ func New(workers []string, schedulerType string, dbType string) *Manager {
 		Scheduler:     s,
 	}
 
-	var ts, es store.Store
+	var ts, es, js, cs, ws, ss, rs store.Store
 	switch dbType {
 	case "memory":
 		ts = store.NewInMemoryTaskStore()
 		es = store.NewInMemoryTaskEventStore()
+		js = store.NewInMemoryStore[task.Job]("job")
+		cs = store.NewInMemoryStore[task.CronJob]("cron job")
+		ws = store.NewInMemoryStore[task.Workflow]("workflow")
+		ss = store.NewInMemoryStore[task.Service]("service")
+		rs = store.NewInMemoryStore[task.ServiceRevision]("service revision")
 	case "persistent":
 		ts, _ = store.NewTaskStore("tasks.db", 0600, "tasks")
 		es, _ = store.NewEventStore("events.db", 0600, "events")
+		js, _ = store.NewBoltStore[task.Job]("job", "jobs.db", 0600, "jobs")
+		cs, _ = store.NewBoltStore[task.CronJob]("cron job", "cronjobs.db", 0600, "cronjobs")
+		ws, _ = store.NewBoltStore[task.Workflow]("workflow", "workflows.db", 0600, "workflows")
+		ss, _ = store.NewBoltStore[task.Service]("service", "services.db", 0600, "services")
+		rs, _ = store.NewBoltStore[task.ServiceRevision]("service revision", "revisions.db", 0600, "revisions")
 	}
 	m.TaskDb = ts
 	m.EventDb = es
+	m.JobDb = js
+	m.CronDb = cs
+	m.WorkflowDb = ws
+	m.ServiceDb = ss
+	m.RevisionDb = rs
 
 	return &m
 }
@@ -89,18 +132,182 @@ func (m *Manager) GetTasks() []*task.Task {
 }
 
 func (m *Manager) AddTask(te task.TaskEvent) {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
 	m.Pending.Enqueue(te)
 }
 
+// taskWorker returns the worker a task, or a member of a task group, was
+// sent to.
+func (m *Manager) taskWorker(id uuid.UUID) (string, bool) {
+	m.workersMu.RLock()
+	defer m.workersMu.RUnlock()
+	w, ok := m.TaskWorkerMap[id]
+	return w, ok
+}
+
+// assignTask records that a task and its members run on the worker.
+func (m *Manager) assignTask(worker string, t *task.Task) {
+	m.workersMu.Lock()
+	defer m.workersMu.Unlock()
+	m.WorkerTaskMap[worker] = append(m.WorkerTaskMap[worker], t.ID)
+	m.TaskWorkerMap[t.ID] = worker
+	for _, member := range t.Members {
+		m.TaskWorkerMap[member.ID] = worker
+	}
+}
+
+// unassignTask forgets which worker a task and its members ran on.
+func (m *Manager) unassignTask(t *task.Task) {
+	m.workersMu.Lock()
+	defer m.workersMu.Unlock()
+	w, ok := m.TaskWorkerMap[t.ID]
+	if !ok {
+		return
+	}
+
+	var ids []uuid.UUID
+	for _, id := range m.WorkerTaskMap[w] {
+		if id != t.ID {
+			ids = append(ids, id)
+		}
+	}
+	m.WorkerTaskMap[w] = ids
+	delete(m.TaskWorkerMap, t.ID)
+	for _, member := range t.Members {
+		delete(m.TaskWorkerMap, member.ID)
+	}
+}
+
+// nextPending takes the next event off the pending queue.
+func (m *Manager) nextPending() (task.TaskEvent, bool) {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	if m.Pending.Len() == 0 {
+		return task.TaskEvent{}, false
+	}
+
+	return m.Pending.Dequeue().(task.TaskEvent), true
+}
+
+// GetTaskLogs asks the worker running the task for its logs. The query is
+// passed through unchanged and the caller must close the response body.
+func (m *Manager) GetTaskLogs(ctx context.Context, taskID uuid.UUID, query string) (*http.Response, error) {
+	w, ok := m.taskWorker(taskID)
+	if !ok {
+		return nil, fmt.Errorf("no worker found for task %v", taskID)
+	}
+
+	url := fmt.Sprintf("http://%s/tasks/%s/logs?%s", w, taskID, query)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request to %s: %w", url, err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to worker %s: %w", w, err)
+	}
+
+	return resp, nil
+}
+
+// PullImages asks every worker to pull the images, warming their caches
+// before tasks that use them are scheduled. Results are keyed by worker.
+func (m *Manager) PullImages(images []string) map[string][]worker.ImagePullResult {
+	body, _ := json.Marshal(worker.ImagePullRequest{Images: images})
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string][]worker.ImagePullResult)
+	for _, w := range m.Workers {
+		wg.Add(1)
+		go func(w string) {
+			defer wg.Done()
+			r := pullImagesOnWorker(w, body, images)
+			mu.Lock()
+			results[w] = r
+			mu.Unlock()
+		}(w)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// pullImagesOnWorker sends a pull request to one worker. If the request as a
+// whole fails, every image is reported with that error.
+func pullImagesOnWorker(w string, body []byte, images []string) []worker.ImagePullResult {
+	failed := func(msg string) []worker.ImagePullResult {
+		log.Printf("Error pulling images on %s: %s\n", w, msg)
+		var results []worker.ImagePullResult
+		for _, image := range images {
+			results = append(results, worker.ImagePullResult{Image: image, Error: msg})
+		}
+		return results
+	}
+
+	url := fmt.Sprintf("http://%s/images", w)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return failed(fmt.Sprintf("error connecting to worker: %v", err))
+	}
+	defer resp.Body.Close()
+
+	d := json.NewDecoder(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		e := worker.ErrResponse{}
+		err := d.Decode(&e)
+		if err != nil {
+			return failed(fmt.Sprintf("worker returned %s", resp.Status))
+		}
+		return failed(e.Message)
+	}
+
+	var results []worker.ImagePullResult
+	err = d.Decode(&results)
+	if err != nil {
+		return failed(fmt.Sprintf("error decoding response: %v", err))
+	}
+
+	return results
+}
+
+// ExecTask starts an exec session on the worker running the task. When the
+// worker accepts it, the response body is the upgraded connection.
+func (m *Manager) ExecTask(taskID uuid.UUID, body []byte) (*http.Response, error) {
+	w, ok := m.taskWorker(taskID)
+	if !ok {
+		return nil, fmt.Errorf("no worker found for task %v", taskID)
+	}
+
+	url := fmt.Sprintf("http://%s/tasks/%s/exec", w, taskID)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request to %s: %w", url, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", stream.Protocol)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to worker %s: %w", w, err)
+	}
+
+	return resp, nil
+}
+
 func (m *Manager) SelectWorker(t task.Task) (*node.Node, error) {
-	candidates := m.Scheduler.SelectCandidateNodes(t, m.WorkerNodes)
+	r := t.Resources()
+	candidates := m.Scheduler.SelectCandidateNodes(r, m.WorkerNodes)
 	if candidates == nil {
 		msg := fmt.Sprintf("No available candidates match resource request for task %v", t.ID)
 		err := errors.New(msg)
 		return nil, err
 	}
 
-	scores := m.Scheduler.Score(t, candidates)
+	scores := m.Scheduler.Score(r, candidates)
 
 	selectedNode := m.Scheduler.Pick(scores, candidates)
 
@@ -127,9 +334,7 @@ func (m *Manager) ProcessTasks() {
 }
 
 func (m *Manager) SendWork() {
-	if m.Pending.Len() > 0 {
-		e := m.Pending.Dequeue()
-		te := e.(task.TaskEvent)
+	if te, ok := m.nextPending(); ok {
 		err := m.EventDb.Put(te.ID.String(), &te)
 		if err != nil {
 			log.Printf("error attempting to store task event %s: %s\n", te.ID.String(), err)
@@ -137,7 +342,7 @@ func (m *Manager) SendWork() {
 		}
 		log.Printf("Pulled %v off pending queue", te)
 
-		taskWorker, ok := m.TaskWorkerMap[te.Task.ID]
+		taskWorker, ok := m.taskWorker(te.Task.ID)
 		if ok {
 			result, err := m.TaskDb.Get(te.Task.ID.String())
 			if err != nil {
@@ -152,7 +357,18 @@ func (m *Manager) SendWork() {
 			}
 
 			if te.State == task.Completed && task.ValidStateTransition(persistedTask.State, te.State) {
-				m.stopTask(taskWorker, te.Task.ID.String())
+				err := m.stopTask(taskWorker, te.Task.ID.String())
+				if err != nil {
+					// Send the stop again later.
+					log.Printf("Error stopping task %v: %v\n", te.Task.ID, err)
+					m.AddTask(te)
+					return
+				}
+				persistedTask.State = task.Stopping
+				err = m.TaskDb.Put(persistedTask.ID.String(), persistedTask)
+				if err != nil {
+					log.Printf("Error updating task %s in database: %v", persistedTask.ID.String(), err)
+				}
 				return
 			}
 
@@ -160,10 +376,26 @@ func (m *Manager) SendWork() {
 			return
 		}
 
+		if result, err := m.TaskDb.Get(te.Task.ID.String()); err == nil && result.(*task.Task).Reason == task.ReasonStopped {
+			log.Printf("Task %v was stopped before it was scheduled\n", te.Task.ID)
+			return
+		}
+
+		// Members get their own IDs so requests for their logs and exec
+		// sessions can be routed to the worker running the group.
+		for i := range te.Task.Members {
+			if te.Task.Members[i].ID == uuid.Nil {
+				te.Task.Members[i].ID = uuid.New()
+			}
+		}
+
 		t := te.Task
 		w, err := m.SelectWorker(t)
 		if err != nil {
+			// Try again later, when a worker may have room for it.
 			log.Printf("error selecting worker for task %s: %v\n", t.ID, err)
+			m.AddTask(te)
+			return
 		}
 
 		t.State = task.Scheduled
@@ -176,13 +408,12 @@ func (m *Manager) SendWork() {
 			log.Printf("Unable to marshal task object: %v.\n", t)
 		}
 
-		m.WorkerTaskMap[w.Name] = append(m.WorkerTaskMap[w.Name], te.Task.ID)
-		m.TaskWorkerMap[t.ID] = w.Name
+		m.assignTask(w.Name, &t)
 		url := fmt.Sprintf("http://%s/tasks", w.Name)
 		resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
 		if err != nil {
 			log.Printf("Error connecting to %v: %v\n", w.Name, err)
-			m.Pending.Enqueue(te)
+			m.AddTask(te)
 			return
 		}
 
@@ -216,8 +447,8 @@ func (m *Manager) DoHealthChecks() {
 		m.doHealthChecks()
 
 		log.Println("Task health checks completed")
-		log.Println("Sleeping for 60 seconds")
-		time.Sleep(60 * time.Second)
+		log.Println("Sleeping for 10 seconds")
+		time.Sleep(10 * time.Second)
 	}
 }
 
@@ -254,14 +485,34 @@ func (m *Manager) updateTasks() {
 					result)
 				continue
 			}
+			// The worker map is only kept in memory, so learn it again
+			// after the manager restarts.
+			if _, ok := m.taskWorker(t.ID); !ok {
+				m.assignTask(worker, t)
+			}
 
-			if taskPersisted.State != t.State {
+			// The worker only moves a task to Stopping once it dequeues the
+			// stop request, so don't let it report the task as Running again.
+			stopRequested := taskPersisted.State == task.Stopping && t.State == task.Running
+			// Likewise a task being restarted is reported as it last ran
+			// until the worker starts it again.
+			restartRequested := taskPersisted.State == task.Scheduled && t.StartTime.Equal(taskPersisted.StartTime)
+			if taskPersisted.State != t.State && !stopRequested && !restartRequested {
 				taskPersisted.State = t.State
 			}
 			taskPersisted.StartTime = t.StartTime
 			taskPersisted.FinishTime = t.FinishTime
 			taskPersisted.ContainerID = t.ContainerID
 			taskPersisted.HostPorts = t.HostPorts
+			taskPersisted.AppliedLimits = t.AppliedLimits
+			taskPersisted.ExitCode = t.ExitCode
+			taskPersisted.OOMKilled = t.OOMKilled
+			taskPersisted.TerminationMessage = t.TerminationMessage
+			taskPersisted.Members = t.Members
+			taskPersisted.Reason = t.Reason
+			taskPersisted.InitContainers = t.InitContainers
+			taskPersisted.Liveness = t.Liveness
+			taskPersisted.Readiness = t.Readiness
 
 			err = m.TaskDb.Put(taskPersisted.ID.String(), taskPersisted)
 			if err != nil {
@@ -271,82 +522,172 @@ func (m *Manager) updateTasks() {
 	}
 }
 
+// checkTaskHealth returns an error if the worker running the task has found
+// its liveness probe failing. The worker runs the probes, so this only looks
+// at what it last reported.
 func (m *Manager) checkTaskHealth(t task.Task) error {
-	log.Printf("Calling health check for task %s: %s\n", t.ID, t.HealthCheck)
-
-	w := m.TaskWorkerMap[t.ID]
-	hostPort := getHostPort(t.ExposedPorts)
-	worker := strings.Split(w, ":")
-	url := fmt.Sprintf("http://%s:%s%s", worker[0], *hostPort, t.HealthCheck)
-
-	log.Printf("Calling health check for task %s:%s\n", t.ID, url)
-	// resp, err := http.Get(url)
-	// if err != nil {
-	// 	msg := fmt.Sprintf("Error connecting to health check %s", url)
-	// 	log.Println(msg)
-	// 	return errors.New(msg)
-	// }
-	// if resp.StatusCode != http.StatusOK {
-	// 	msg := fmt.Sprintf("Error health check for task %s did not return 200\n", t.ID)
-	// 	log.Println(msg)
-	// 	return errors.New(msg)
-	// }
-	log.Printf("Task %s health check response: %v\n", t.ID, 200)
+	liveness, _ := t.Probes()
+	if liveness == nil {
+		return nil
+	}
+	if !t.Liveness.Passing && t.Liveness.Failures > 0 {
+		msg := fmt.Sprintf("liveness probe for task %s failed %d times: %s", t.ID, t.Liveness.Failures, t.Liveness.Message)
+		log.Println(msg)
+		return errors.New(msg)
+	}
 
 	return nil
 }
 
 func (m *Manager) doHealthChecks() {
 	for _, t := range m.GetTasks() {
-		if t.State == task.Running && t.RestartCount < 3 {
+		// Batch tasks run to completion and their job replaces any that
+		// fail.
+		if t.JobID != uuid.Nil {
+			continue
+		}
+		switch t.State {
+		case task.Running:
 			err := m.checkTaskHealth(*t)
 			if err != nil {
-				if t.RestartCount < 3 {
-					m.restartTask(t)
+				m.scheduleRestart(t)
+				continue
+			}
+			// A task that recovered before its restart was due keeps
+			// running, and a later failure waits out a fresh backoff.
+			if !t.NextRestart.IsZero() {
+				log.Printf("Task %s is healthy again, cancelling its restart\n", t.ID)
+				t.NextRestart = time.Time{}
+				err := m.TaskDb.Put(t.ID.String(), t)
+				if err != nil {
+					log.Printf("Error updating task %s in database: %v", t.ID.String(), err)
 				}
 			}
-		} else if t.State == task.Failed && t.RestartCount < 3 {
-			m.restartTask(t)
+		case task.Completed, task.Failed:
+			m.scheduleRestart(t)
 		}
 	}
 }
 
-func (m *Manager) stopTask(worker string, taskID string) {
+// scheduleRestart restarts a task if its restart policy allows, once the
+// policy's backoff has passed. The first call only works out when the
+// restart is due.
+func (m *Manager) scheduleRestart(t *task.Task) {
+	if t.NextRestart.IsZero() {
+		// A task that ran for long enough has recovered from any earlier
+		// crashes, so its backoff starts over.
+		finished := t.FinishTime
+		if finished.IsZero() {
+			finished = time.Now().UTC()
+		}
+		if t.RestartCount > 0 && finished.Sub(t.StartTime) >= t.RestartPolicy.ResetWindow() {
+			t.RestartCount = 0
+		}
+		if !t.RestartPolicy.ShouldRestart(t) {
+			return
+		}
+
+		t.NextRestart = time.Now().UTC().Add(t.RestartPolicy.Backoff(t.RestartCount))
+		log.Printf("Task %s will be restarted at %v\n", t.ID, t.NextRestart)
+		err := m.TaskDb.Put(t.ID.String(), t)
+		if err != nil {
+			log.Printf("Error updating task %s in database: %v", t.ID.String(), err)
+		}
+		return
+	}
+
+	if time.Now().Before(t.NextRestart) || !t.RestartPolicy.ShouldRestart(t) {
+		return
+	}
+	m.restartTask(t)
+}
+
+// stopTask asks the worker to stop a task. The worker only queues the stop,
+// so the task is still running when this returns.
+func (m *Manager) stopTask(worker string, taskID string) error {
 	client := &http.Client{}
 	url := fmt.Sprintf("http://%s/tasks/%s", worker, taskID)
 
 	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
-		log.Printf("error creating request to delete task %s: %v\n", taskID, err)
-		return
+		return fmt.Errorf("error creating request to delete task %s: %w", taskID, err)
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Printf("error connecting to worker at %s: %v\n", url, err)
-		return
+		return fmt.Errorf("error connecting to worker at %s: %w", url, err)
 	}
-	if resp.StatusCode != 204 {
-		log.Printf("Error sending request: %v\n", err)
-		return
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("worker at %s returned %s", url, resp.Status)
 	}
 
 	log.Printf("task %s has been scheduled to be stopped", taskID)
+	return nil
 }
 
-func (m *Manager) restartTask(t *task.Task) {
-	w := m.TaskWorkerMap[t.ID]
-	t.State = task.Scheduled
-	t.RestartCount++
-	err := m.TaskDb.Put(t.ID.String(), t)
+// deleteTask forgets a finished task once its worker has removed its
+// container. The task is kept if the worker cannot be reached, so deleting it
+// can be tried again.
+func (m *Manager) deleteTask(id uuid.UUID) error {
+	result, err := m.TaskDb.Get(id.String())
+	if err != nil {
+		// Already deleted.
+		return nil
+	}
+	t := result.(*task.Task)
+
+	if w, ok := m.taskWorker(t.ID); ok {
+		err := removeTaskOnWorker(w, t.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.unassignTask(t)
+	return m.TaskDb.Delete(t.ID.String())
+}
+
+// removeTaskOnWorker asks a worker to remove a finished task. A worker that
+// no longer knows the task has nothing left to remove.
+func removeTaskOnWorker(worker string, id uuid.UUID) error {
+	url := fmt.Sprintf("http://%s/tasks/%s?remove=true", worker, id)
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request to %s: %w", url, err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		log.Printf("Error updating task %s in database: %v", t.ID.String(), err)
+		return fmt.Errorf("error connecting to worker %s: %w", worker, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("worker %s returned %s", worker, resp.Status)
+	}
+
+	return nil
+}
+
+// restartTask sends the task to its worker to be started again. The task is
+// only updated once the worker has accepted it, so a failed attempt is
+// retried on the next health check.
+func (m *Manager) restartTask(t *task.Task) {
+	w, ok := m.taskWorker(t.ID)
+	if !ok {
+		log.Printf("No worker found for task %v\n", t.ID)
+		return
 	}
+
+	restarted := *t
+	restarted.State = task.Scheduled
+	restarted.RestartCount++
+	restarted.NextRestart = time.Time{}
 	te := task.TaskEvent{
 		ID:        uuid.New(),
 		State:     task.Running,
 		Timestamp: time.Now(),
-		Task:      *t,
+		Task:      restarted,
 	}
 
 	data, err := json.Marshal(te)
@@ -358,9 +699,9 @@ func (m *Manager) restartTask(t *task.Task) {
 	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
 	if err != nil {
 		log.Printf("Error connecting to %v: %v", w, err)
-		m.Pending.Enqueue(t)
 		return
 	}
+	defer resp.Body.Close()
 
 	d := json.NewDecoder(resp.Body)
 	if resp.StatusCode != http.StatusCreated {
@@ -374,23 +715,9 @@ func (m *Manager) restartTask(t *task.Task) {
 		return
 	}
 
-	newTask := task.Task{}
-	err = d.Decode(&newTask)
+	err = m.TaskDb.Put(restarted.ID.String(), &restarted)
 	if err != nil {
-		fmt.Printf("Error decoding response: %s\n", err.Error())
-		return
+		log.Printf("Error updating task %s in database: %v", restarted.ID.String(), err)
 	}
-	log.Printf("%#v\n", t)
-}
-
-func getHostPort(ports []nettypes.PortMapping) *string {
-	for _, k := range ports {
-		p := k.HostPort
-		sp := fmt.Sprintf("%d", p)
-		return &sp
-	}
-
-	log.Print("No ports found in portmap")
-	noPort := ""
-	return &noPort
+	log.Printf("Restarted task %v (restart %d)\n", restarted.ID, restarted.RestartCount)
 }
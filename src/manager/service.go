@@ -0,0 +1,704 @@
+package manager
+
+import (
+	"cube/task"
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// AddService stores a new service for ProcessServices to start. The
+// submitter is recorded with the service's first revision.
+func (m *Manager) AddService(s task.Service, submitter string) (*task.Service, error) {
+	err := s.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	m.serviceMu.Lock()
+	defer m.serviceMu.Unlock()
+	if _, err := m.ServiceDb.Get(s.Name); err == nil {
+		return nil, fmt.Errorf("service %s already exists", s.Name)
+	}
+
+	s.Revision = 1
+	s.ActiveRevision = 1
+	s.CreationTime = time.Now().UTC()
+	s.Running, s.Ready = 0, 0
+	s.Message = ""
+	s.Rollout = task.Rollout{StartTime: s.CreationTime}
+	err = m.ServiceDb.Put(s.Name, &s)
+	if err != nil {
+		return nil, fmt.Errorf("error storing service %s: %w", s.Name, err)
+	}
+	m.recordRevision(&s, submitter, "created")
+
+	return &s, nil
+}
+
+// UpdateService replaces a service's spec. A change to its template makes a
+// new revision, and the service's tasks are rolled over to it.
+func (m *Manager) UpdateService(s task.Service, submitter string) (*task.Service, error) {
+	err := s.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	m.serviceMu.Lock()
+	defer m.serviceMu.Unlock()
+	current, err := m.GetService(s.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	current.Replicas = s.Replicas
+	current.Strategy = s.Strategy
+	changed := !reflect.DeepEqual(current.Template, s.Template)
+	if changed {
+		m.newRevision(current, s.Template)
+	}
+	err = m.ServiceDb.Put(current.Name, current)
+	if err != nil {
+		return nil, fmt.Errorf("error updating service %s: %w", current.Name, err)
+	}
+	if changed {
+		m.recordRevision(current, submitter, "updated")
+	}
+
+	return current, nil
+}
+
+// UndoService rolls a service back to the template of an earlier revision,
+// or of the revision before the current one when toRevision is zero. The
+// old template becomes a new revision.
+func (m *Manager) UndoService(name string, toRevision int, submitter string) (*task.Service, error) {
+	m.serviceMu.Lock()
+	defer m.serviceMu.Unlock()
+	s, err := m.GetService(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var target *task.ServiceRevision
+	for _, r := range m.GetRevisions(name) {
+		switch {
+		case toRevision != 0 && r.Revision == toRevision:
+			target = r
+		case toRevision == 0 && r.Revision < s.Revision:
+			// Revisions are in order, so the last one found is the latest.
+			target = r
+		}
+	}
+	switch {
+	case target == nil && toRevision != 0:
+		return nil, fmt.Errorf("service %s has no revision %d", name, toRevision)
+	case target == nil:
+		return nil, fmt.Errorf("service %s has no earlier revision", name)
+	case target.Revision == s.Revision:
+		return nil, fmt.Errorf("service %s is already at revision %d", name, s.Revision)
+	}
+
+	m.newRevision(s, target.Template)
+	err = m.ServiceDb.Put(s.Name, s)
+	if err != nil {
+		return nil, fmt.Errorf("error updating service %s: %w", s.Name, err)
+	}
+	m.recordRevision(s, submitter, fmt.Sprintf("rolled back to revision %d", target.Revision))
+
+	return s, nil
+}
+
+// GetRevisions returns the recorded revisions of a service, oldest first.
+func (m *Manager) GetRevisions(name string) []*task.ServiceRevision {
+	revisionList, err := m.RevisionDb.List()
+	if err != nil {
+		log.Printf("error getting list of service revisions: %v\n", err)
+		return nil
+	}
+
+	var revisions []*task.ServiceRevision
+	for _, r := range revisionList.([]*task.ServiceRevision) {
+		if r.Service == name {
+			revisions = append(revisions, r)
+		}
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+
+	return revisions
+}
+
+// newRevision gives the service a new template and starts rolling its tasks
+// over to it.
+func (m *Manager) newRevision(s *task.Service, template task.Task) {
+	s.Template = template
+	s.Revision++
+	s.Rollout = task.Rollout{StartTime: time.Now().UTC()}
+	log.Printf("Service %s updated to revision %d\n", s.Name, s.Revision)
+}
+
+func (m *Manager) recordRevision(s *task.Service, submitter string, cause string) {
+	r := task.ServiceRevision{
+		Service:   s.Name,
+		Revision:  s.Revision,
+		Template:  s.Template,
+		Submitter: submitter,
+		Timestamp: time.Now().UTC(),
+		Cause:     cause,
+	}
+	err := m.RevisionDb.Put(revisionKey(s.Name, s.Revision), &r)
+	if err != nil {
+		log.Printf("Error storing revision %d of service %s: %v", s.Revision, s.Name, err)
+	}
+}
+
+func revisionKey(name string, revision int) string {
+	return fmt.Sprintf("%s/%d", name, revision)
+}
+
+func (m *Manager) GetServices() []*task.Service {
+	serviceList, err := m.ServiceDb.List()
+	if err != nil {
+		log.Printf("error getting list of services: %v\n", err)
+		return nil
+	}
+
+	return serviceList.([]*task.Service)
+}
+
+func (m *Manager) GetService(name string) (*task.Service, error) {
+	result, err := m.ServiceDb.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*task.Service), nil
+}
+
+// ScaleService changes how many replicas of a service should run. The next
+// reconcile starts or stops tasks to match.
+func (m *Manager) ScaleService(name string, replicas int) (*task.Service, error) {
+	if replicas < 0 {
+		return nil, fmt.Errorf("replicas must not be negative")
+	}
+
+	m.serviceMu.Lock()
+	defer m.serviceMu.Unlock()
+	s, err := m.GetService(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Replicas = replicas
+	err = m.ServiceDb.Put(s.Name, s)
+	if err != nil {
+		return nil, fmt.Errorf("error updating service %s: %w", s.Name, err)
+	}
+
+	return s, nil
+}
+
+// PauseRollout stops a service's rollout where it is.
+func (m *Manager) PauseRollout(name string) (*task.Service, error) {
+	m.serviceMu.Lock()
+	defer m.serviceMu.Unlock()
+	s, err := m.GetService(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Rollout.Paused = true
+	s.Rollout.Message = "paused"
+	err = m.ServiceDb.Put(s.Name, s)
+	if err != nil {
+		return nil, fmt.Errorf("error updating service %s: %w", s.Name, err)
+	}
+
+	return s, nil
+}
+
+// ResumeRollout carries on with a paused rollout. Tasks that failed before
+// it was resumed don't pause it again.
+func (m *Manager) ResumeRollout(name string) (*task.Service, error) {
+	m.serviceMu.Lock()
+	defer m.serviceMu.Unlock()
+	s, err := m.GetService(name)
+	if err != nil {
+		return nil, err
+	}
+	if !s.Rollout.Paused {
+		return nil, fmt.Errorf("rollout of service %s is not paused", name)
+	}
+
+	s.Rollout.Paused = false
+	s.Rollout.StartTime = time.Now().UTC()
+	s.Rollout.Message = "resumed"
+	err = m.ServiceDb.Put(s.Name, s)
+	if err != nil {
+		return nil, fmt.Errorf("error updating service %s: %w", s.Name, err)
+	}
+
+	return s, nil
+}
+
+// PromoteRollout approves a canary or blue/green rollout, letting it go on
+// once its new tasks are ready.
+func (m *Manager) PromoteRollout(name string) (*task.Service, error) {
+	m.serviceMu.Lock()
+	defer m.serviceMu.Unlock()
+	s, err := m.GetService(name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case s.Strategy.Type != task.Canary && s.Strategy.Type != task.BlueGreen:
+		return nil, fmt.Errorf("service %s uses rolling updates, which are not promoted", name)
+	case s.Rollout.Complete:
+		return nil, fmt.Errorf("rollout of revision %d of service %s has already completed", s.Revision, name)
+	case s.Rollout.Promoted:
+		return nil, fmt.Errorf("rollout of revision %d of service %s has already been promoted", s.Revision, name)
+	}
+
+	s.Rollout.Promoted = true
+	s.Rollout.Message = "promoted"
+	err = m.ServiceDb.Put(s.Name, s)
+	if err != nil {
+		return nil, fmt.Errorf("error updating service %s: %w", s.Name, err)
+	}
+
+	return s, nil
+}
+
+// GetEndpoints returns the ready tasks of a service that traffic should be
+// sent to. Under a blue/green strategy only tasks of the active revision
+// are sent traffic; otherwise every version is.
+func (m *Manager) GetEndpoints(name string) ([]task.Endpoint, error) {
+	s, err := m.GetService(name)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := []task.Endpoint{}
+	for _, t := range m.serviceTasks(name) {
+		if !t.Ready() {
+			continue
+		}
+		if s.Strategy.Type == task.BlueGreen && t.Revision != s.ActiveRevision {
+			continue
+		}
+		endpoints = append(endpoints, task.Endpoint{
+			TaskID:   t.ID,
+			Name:     t.Name,
+			Version:  t.Version,
+			Revision: t.Revision,
+			Address:  m.taskAddress(t),
+		})
+	}
+
+	return endpoints, nil
+}
+
+// taskAddress returns where a task's first exposed port can be reached on
+// its worker: the host port it is published on, or else the port itself,
+// which is where tasks run by the process runtime listen.
+func (m *Manager) taskAddress(t *task.Task) string {
+	w, ok := m.taskWorker(t.ID)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(w)
+	if err != nil {
+		host = w
+	}
+	if len(t.ExposedPorts) == 0 {
+		return host
+	}
+
+	p := t.ExposedPorts[0]
+	protocol := p.Protocol
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	port := strconv.Itoa(int(p.ContainerPort))
+	bindings := t.HostPorts[fmt.Sprintf("%d/%s", p.ContainerPort, protocol)]
+	switch {
+	case p.HostPort != 0:
+		port = strconv.Itoa(int(p.HostPort))
+	case len(bindings) > 0 && bindings[0].HostPort != "":
+		port = bindings[0].HostPort
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// DeleteService removes a service and stops all of its tasks.
+func (m *Manager) DeleteService(name string) error {
+	m.serviceMu.Lock()
+	defer m.serviceMu.Unlock()
+	if _, err := m.GetService(name); err != nil {
+		return err
+	}
+	err := m.ServiceDb.Delete(name)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range m.serviceTasks(name) {
+		m.retireTask(t)
+	}
+	for _, r := range m.GetRevisions(name) {
+		err := m.RevisionDb.Delete(revisionKey(name, r.Revision))
+		if err != nil {
+			log.Printf("Error deleting revision %d of service %s: %v", r.Revision, name, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) ProcessServices() {
+	for {
+		log.Println("Reconciling services")
+		m.processServices()
+		log.Println("Sleeping for 10 seconds")
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func (m *Manager) processServices() {
+	for _, s := range m.GetServices() {
+		m.serviceMu.Lock()
+		// Reconcile the service as it is now, in case it was changed or
+		// deleted since the list was read.
+		s, err := m.GetService(s.Name)
+		if err == nil {
+			m.reconcileService(s)
+			err = m.ServiceDb.Put(s.Name, s)
+			if err != nil {
+				log.Printf("Error updating service %s in database: %v", s.Name, err)
+			}
+		}
+		m.serviceMu.Unlock()
+	}
+}
+
+// reconcileService compares the service's desired replicas with its live
+// tasks, then submits new tasks or stops surplus ones to close the gap.
+// While tasks of older revisions are live, it rolls them over to the
+// current one instead.
+func (m *Manager) reconcileService(s *task.Service) {
+	tasks := m.serviceTasks(s.Name)
+	var current, outdated []*task.Task
+	for _, t := range tasks {
+		switch {
+		case !taskLive(t):
+		case t.Revision == s.Revision:
+			current = append(current, t)
+		default:
+			outdated = append(outdated, t)
+		}
+	}
+
+	switch {
+	case len(outdated) == 0:
+		current = m.scaleService(s, current)
+		s.ActiveRevision = s.Revision
+	case rolloutHalted(s, tasks):
+	case s.Strategy.Type == task.Canary:
+		current, outdated = m.canaryService(s, current, outdated)
+	case s.Strategy.Type == task.BlueGreen:
+		current, outdated = m.blueGreenService(s, current, outdated)
+	default:
+		current, outdated = m.rollService(s, current, outdated)
+	}
+
+	s.Running, s.Ready = 0, 0
+	s.Rollout.Updated, s.Rollout.UpdatedReady = len(current), 0
+	s.Rollout.Outdated = len(outdated)
+	for _, t := range append(current, outdated...) {
+		if t.State == task.Running {
+			s.Running++
+		}
+		if t.Ready() {
+			s.Ready++
+		}
+	}
+	for _, t := range current {
+		if t.Ready() {
+			s.Rollout.UpdatedReady++
+		}
+	}
+	s.Rollout.Complete = len(outdated) == 0 && s.Rollout.UpdatedReady == s.Replicas
+	if s.Rollout.Complete {
+		s.Rollout.Message = fmt.Sprintf("revision %d is running", s.Revision)
+	}
+}
+
+// scaleService starts or stops tasks until Replicas of them are live and
+// returns the tasks left.
+func (m *Manager) scaleService(s *task.Service, live []*task.Task) []*task.Task {
+	switch diff := s.Replicas - len(live); {
+	case diff > 0:
+		for i := 0; i < diff; i++ {
+			live = append(live, m.startServiceTask(s))
+		}
+		s.Message = fmt.Sprintf("scaled up from %d to %d replicas", s.Replicas-diff, s.Replicas)
+	case diff < 0:
+		sortSurplus(live)
+		for _, t := range live[:-diff] {
+			m.retireTask(t)
+			log.Printf("Service %s stopped task %v\n", s.Name, t.ID)
+		}
+		live = live[-diff:]
+		s.Message = fmt.Sprintf("scaled down from %d to %d replicas", s.Replicas-diff, s.Replicas)
+	default:
+		s.Message = ""
+	}
+
+	return live
+}
+
+// rolloutHalted reports whether a service's rollout is paused, first
+// pausing it if a task of the new revision has failed.
+func rolloutHalted(s *task.Service, tasks []*task.Task) bool {
+	if s.Rollout.Paused {
+		s.Message = "rollout paused"
+		return true
+	}
+	if msg := rolloutFailure(s, tasks); msg != "" {
+		log.Printf("Pausing rollout of service %s: %s\n", s.Name, msg)
+		s.Rollout.Paused = true
+		s.Rollout.Message = msg
+		s.Message = "rollout paused"
+		return true
+	}
+
+	return false
+}
+
+// rollService takes one step of a rolling update. Outdated tasks are
+// stopped as long as enough tasks stay ready, with those not ready stopped
+// first since they serve nothing, then tasks of the current revision are
+// started as far as the surge allows. So the rollout only moves on as new
+// tasks become ready.
+func (m *Manager) rollService(s *task.Service, current, outdated []*task.Task) ([]*task.Task, []*task.Task) {
+	surge, unavailable := s.Strategy.Limits()
+	if len(current) > s.Replicas {
+		current = m.scaleService(s, current)
+	}
+
+	ready := 0
+	for _, t := range append(current, outdated...) {
+		if t.Ready() {
+			ready++
+		}
+	}
+	budget := ready - (s.Replicas - unavailable)
+	sortSurplus(outdated)
+	var kept []*task.Task
+	for _, t := range outdated {
+		switch {
+		case !t.Ready():
+		case budget > 0:
+			budget--
+		default:
+			kept = append(kept, t)
+			continue
+		}
+		m.retireTask(t)
+		log.Printf("Service %s replaced task %v of revision %d\n", s.Name, t.ID, t.Revision)
+	}
+	outdated = kept
+
+	start := min(s.Replicas-len(current), s.Replicas+surge-len(current)-len(outdated))
+	for i := 0; i < start; i++ {
+		current = append(current, m.startServiceTask(s))
+	}
+
+	s.Message = fmt.Sprintf("rolling out revision %d", s.Revision)
+	s.Rollout.Message = fmt.Sprintf("%d of %d tasks updated, %d old tasks left", len(current), s.Replicas, len(outdated))
+
+	return current, outdated
+}
+
+// canaryService runs the canary's new tasks next to the outdated ones until
+// the canary is promoted, then rolls the rest of the tasks over.
+func (m *Manager) canaryService(s *task.Service, current, outdated []*task.Task) ([]*task.Task, []*task.Task) {
+	if s.Rollout.Promoted {
+		return m.rollService(s, current, outdated)
+	}
+
+	want := s.Canaries()
+	for len(current) < want {
+		current = append(current, m.startServiceTask(s))
+	}
+	if len(current) > want {
+		sortSurplus(current)
+		for _, t := range current[:len(current)-want] {
+			m.retireTask(t)
+		}
+		current = current[len(current)-want:]
+	}
+	s.Message = fmt.Sprintf("running a canary of revision %d", s.Revision)
+
+	if awaitPromotion(s, current, want) {
+		return m.rollService(s, current, outdated)
+	}
+	return current, outdated
+}
+
+// blueGreenService starts a full set of new tasks next to the outdated
+// ones. Once the rollout is promoted and every new task is ready, traffic
+// switches to the new revision and the outdated tasks are stopped.
+func (m *Manager) blueGreenService(s *task.Service, current, outdated []*task.Task) ([]*task.Task, []*task.Task) {
+	current = m.scaleService(s, current)
+	s.Message = fmt.Sprintf("blue/green deployment of revision %d", s.Revision)
+	if !awaitPromotion(s, current, s.Replicas) {
+		return current, outdated
+	}
+
+	log.Printf("Service %s switched traffic from revision %d to %d\n", s.Name, s.ActiveRevision, s.Revision)
+	s.ActiveRevision = s.Revision
+	for _, t := range outdated {
+		m.retireTask(t)
+	}
+
+	return current, nil
+}
+
+// awaitPromotion reports whether a canary or blue/green rollout can go on:
+// all want of its new tasks are ready and it has been promoted, by hand or
+// automatically once they have been ready for the strategy's delay.
+func awaitPromotion(s *task.Service, current []*task.Task, want int) bool {
+	ready := 0
+	for _, t := range current {
+		if t.Ready() {
+			ready++
+		}
+	}
+	if ready < want {
+		s.Rollout.ReadyTime = time.Time{}
+		s.Rollout.Message = fmt.Sprintf("%d of %d new tasks ready", ready, want)
+		return false
+	}
+
+	now := time.Now().UTC()
+	if s.Rollout.ReadyTime.IsZero() {
+		s.Rollout.ReadyTime = now
+	}
+	promoteAt := s.Rollout.ReadyTime.Add(time.Duration(s.Strategy.PromoteDelay) * time.Second)
+	switch {
+	case s.Rollout.Promoted:
+	case s.Strategy.AutoPromote && !now.Before(promoteAt):
+		log.Printf("Service %s promoted revision %d automatically\n", s.Name, s.Revision)
+		s.Rollout.Promoted = true
+	case s.Strategy.AutoPromote:
+		s.Rollout.Message = fmt.Sprintf("new tasks ready, promoting in %s", promoteAt.Sub(now).Round(time.Second))
+		return false
+	default:
+		s.Rollout.Message = "new tasks ready, waiting to be promoted"
+		return false
+	}
+
+	return true
+}
+
+// rolloutFailure describes a task of the service's current revision that
+// has failed or been restarted since the rollout started, if there is one.
+func rolloutFailure(s *task.Service, tasks []*task.Task) string {
+	since := s.Rollout.StartTime
+	for _, t := range tasks {
+		if t.Revision != s.Revision {
+			continue
+		}
+		switch {
+		case (t.State == task.Completed || t.State == task.Failed) && t.Reason != task.ReasonStopped && t.FinishTime.After(since):
+			msg := fmt.Sprintf("task %s of revision %d exited with code %d", t.Name, t.Revision, t.ExitCode)
+			if t.Reason != "" {
+				msg = fmt.Sprintf("task %s of revision %d failed: %s", t.Name, t.Revision, t.Reason)
+			}
+			return msg
+		case t.RestartCount > 0 && t.StartTime.After(since):
+			return fmt.Sprintf("task %s of revision %d has been restarted %d times", t.Name, t.Revision, t.RestartCount)
+		}
+	}
+
+	return ""
+}
+
+func (m *Manager) startServiceTask(s *task.Service) *task.Task {
+	t := s.NewTask()
+	m.submitTask(t)
+	log.Printf("Service %s created task %v\n", s.Name, t.ID)
+
+	return &t
+}
+
+// taskLive reports whether a task counts towards its service's replicas:
+// it is on its way to running, running, or will be restarted.
+func taskLive(t *task.Task) bool {
+	switch t.State {
+	case task.Pending, task.Scheduled, task.Running:
+		return true
+	case task.Completed, task.Failed:
+		return t.RestartPolicy.ShouldRestart(t)
+	}
+
+	return false
+}
+
+// sortSurplus orders tasks so those doing the least good come first: those
+// not running yet, then those not ready, then the newest.
+func sortSurplus(tasks []*task.Task) {
+	rank := func(t *task.Task) int {
+		switch {
+		case t.State != task.Running:
+			return 0
+		case !t.Ready():
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(tasks, func(i, j int) bool {
+		ri, rj := rank(tasks[i]), rank(tasks[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return tasks[i].StartTime.After(tasks[j].StartTime)
+	})
+}
+
+// retireTask stops a service's task for good. One that has finished and is
+// waiting to be restarted has its restart policy changed so it never is.
+func (m *Manager) retireTask(t *task.Task) {
+	switch t.State {
+	case task.Completed, task.Failed:
+		if t.RestartPolicy.Policy == task.RestartNever && t.NextRestart.IsZero() {
+			return
+		}
+		t.RestartPolicy.Policy = task.RestartNever
+		t.NextRestart = time.Time{}
+		err := m.TaskDb.Put(t.ID.String(), t)
+		if err != nil {
+			log.Printf("Error updating task %s in database: %v", t.ID.String(), err)
+		}
+	default:
+		m.cancelTask(t, "service scaled down")
+	}
+}
+
+func (m *Manager) serviceTasks(name string) []*task.Task {
+	var tasks []*task.Task
+	for _, t := range m.GetTasks() {
+		if t.Service == name {
+			tasks = append(tasks, t)
+		}
+	}
+
+	return tasks
+}
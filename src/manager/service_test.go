@@ -0,0 +1,700 @@
+package manager
+
+import (
+	"cube/task"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestReconcileService(t *testing.T) {
+	tests := []struct {
+		name             string
+		replicas         int
+		running, pending int
+		// what reconciling leaves behind, and what it did to get there
+		wantRunning int
+		wantStarted int
+		wantStopped int
+		wantMessage string
+	}{
+		{
+			name:     "scale up",
+			replicas: 3, running: 1,
+			wantRunning: 1, wantStarted: 2,
+			wantMessage: "scaled up from 1 to 3 replicas",
+		},
+		{
+			name:     "scale down stops tasks not running yet first",
+			replicas: 2, running: 2, pending: 1,
+			wantRunning: 2, wantStopped: 1,
+			wantMessage: "scaled down from 3 to 2 replicas",
+		},
+		{
+			name:     "scale down stops the newest tasks",
+			replicas: 1, running: 3,
+			wantRunning: 1, wantStopped: 2,
+			wantMessage: "scaled down from 3 to 1 replicas",
+		},
+		{
+			name:     "nothing to do",
+			replicas: 2, running: 2,
+			wantRunning: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(nil, "roundrobin", "memory")
+			s := &task.Service{
+				Name:     "web",
+				Replicas: tt.replicas,
+				Template: task.Task{Image: "nginx"},
+			}
+
+			var oldest task.Task
+			add := func(n int, state task.State) {
+				for i := 0; i < n; i++ {
+					tk := task.Task{
+						ID:        uuid.New(),
+						Service:   s.Name,
+						State:     state,
+						StartTime: time.Now().UTC().Add(-time.Duration(i) * time.Minute),
+					}
+					m.TaskDb.Put(tk.ID.String(), &tk)
+					if state == task.Running {
+						oldest = tk
+					}
+				}
+			}
+			add(tt.running, task.Running)
+			add(tt.pending, task.Pending)
+
+			m.reconcileService(s)
+
+			if s.Running != tt.wantRunning {
+				t.Errorf("%d tasks running, want %d", s.Running, tt.wantRunning)
+			}
+			if started := m.Pending.Len(); started != tt.wantStarted {
+				t.Errorf("started %d tasks, want %d", started, tt.wantStarted)
+			}
+			stopped := 0
+			for _, tk := range m.GetTasks() {
+				if tk.Reason == task.ReasonStopped {
+					stopped++
+					if tk.ID == oldest.ID {
+						t.Errorf("stopped the oldest running task")
+					}
+				}
+			}
+			if stopped != tt.wantStopped {
+				t.Errorf("stopped %d tasks, want %d", stopped, tt.wantStopped)
+			}
+			if s.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", s.Message, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestRetireTask(t *testing.T) {
+	tests := []struct {
+		name       string
+		state      task.State
+		policy     string
+		restartDue bool
+		// the task's state, restart policy and reason afterwards
+		wantState  task.State
+		wantPolicy string
+		wantReason string
+	}{
+		{
+			name:      "running task without a worker is marked stopped",
+			state:     task.Running,
+			policy:    task.RestartAlways,
+			wantState: task.Failed, wantPolicy: task.RestartAlways, wantReason: task.ReasonStopped,
+		},
+		{
+			name:      "pending task is marked stopped",
+			state:     task.Pending,
+			policy:    task.RestartAlways,
+			wantState: task.Failed, wantPolicy: task.RestartAlways, wantReason: task.ReasonStopped,
+		},
+		{
+			name:       "failed task due a restart is never restarted",
+			state:      task.Failed,
+			policy:     task.RestartOnFailure,
+			restartDue: true,
+			wantState:  task.Failed, wantPolicy: task.RestartNever,
+		},
+		{
+			name:      "completed task is never restarted",
+			state:     task.Completed,
+			policy:    task.RestartAlways,
+			wantState: task.Completed, wantPolicy: task.RestartNever,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(nil, "roundrobin", "memory")
+			tk := &task.Task{
+				ID:            uuid.New(),
+				Service:       "web",
+				State:         tt.state,
+				RestartPolicy: task.RestartPolicy{Policy: tt.policy},
+			}
+			if tt.restartDue {
+				tk.NextRestart = time.Now().UTC().Add(time.Minute)
+			}
+			m.TaskDb.Put(tk.ID.String(), tk)
+
+			m.retireTask(tk)
+
+			result, err := m.TaskDb.Get(tk.ID.String())
+			if err != nil {
+				t.Fatalf("task not stored: %v", err)
+			}
+			got := result.(*task.Task)
+			if got.State != tt.wantState || got.RestartPolicy.Policy != tt.wantPolicy || got.Reason != tt.wantReason {
+				t.Errorf("task is %v with policy %q and reason %q, want %v, %q and %q",
+					got.State, got.RestartPolicy.Policy, got.Reason, tt.wantState, tt.wantPolicy, tt.wantReason)
+			}
+			if !got.NextRestart.IsZero() {
+				t.Errorf("task still has a restart at %v", got.NextRestart)
+			}
+		})
+	}
+}
+
+func TestRollService(t *testing.T) {
+	tests := []struct {
+		name                       string
+		replicas                   int
+		surge, unavailable         int
+		current, currentPending    int
+		outdated, outdatedNotReady int
+		// what the step leaves behind, and what it did to get there
+		wantCurrent, wantOutdated int
+		wantStarted, wantRetired  int
+	}{
+		{
+			name:     "default surges one task before stopping any",
+			replicas: 3, outdated: 3,
+			wantCurrent: 1, wantOutdated: 3, wantStarted: 1,
+		},
+		{
+			name:     "ready new task lets an old one go",
+			replicas: 3, current: 1, outdated: 3,
+			wantCurrent: 2, wantOutdated: 2, wantStarted: 1, wantRetired: 1,
+		},
+		{
+			name:     "waits for a new task to be ready",
+			replicas: 3, currentPending: 1, outdated: 3,
+			wantCurrent: 1, wantOutdated: 3,
+		},
+		{
+			name:     "unavailability without surge",
+			replicas: 3, unavailable: 1, outdated: 3,
+			wantCurrent: 1, wantOutdated: 2, wantStarted: 1, wantRetired: 1,
+		},
+		{
+			name:     "surge of two",
+			replicas: 4, surge: 2, outdated: 4,
+			wantCurrent: 2, wantOutdated: 4, wantStarted: 2,
+		},
+		{
+			name:     "surge and unavailability together",
+			replicas: 4, surge: 1, unavailable: 1, outdated: 4,
+			wantCurrent: 2, wantOutdated: 3, wantStarted: 2, wantRetired: 1,
+		},
+		{
+			name:     "old tasks that are not ready go first",
+			replicas: 3, outdated: 2, outdatedNotReady: 1,
+			wantCurrent: 2, wantOutdated: 2, wantStarted: 2, wantRetired: 1,
+		},
+		{
+			name:     "surplus new tasks are scaled down",
+			replicas: 2, current: 3, outdated: 1,
+			wantCurrent: 2, wantOutdated: 0, wantRetired: 2,
+		},
+		{
+			name:     "finished rollout",
+			replicas: 3, current: 3,
+			wantCurrent: 3, wantOutdated: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(nil, "roundrobin", "memory")
+			s := &task.Service{
+				Name:     "web",
+				Replicas: tt.replicas,
+				Strategy: task.Strategy{MaxSurge: tt.surge, MaxUnavailable: tt.unavailable},
+				Template: task.Task{Image: "nginx"},
+				Revision: 2,
+			}
+
+			var current, outdated []*task.Task
+			add := func(tasks *[]*task.Task, n int, revision int, state task.State) {
+				for i := 0; i < n; i++ {
+					tk := &task.Task{
+						ID:        uuid.New(),
+						Service:   s.Name,
+						Revision:  revision,
+						State:     state,
+						StartTime: time.Now().UTC(),
+					}
+					*tasks = append(*tasks, tk)
+				}
+			}
+			add(&current, tt.current, 2, task.Running)
+			add(&current, tt.currentPending, 2, task.Pending)
+			add(&outdated, tt.outdated, 1, task.Running)
+			add(&outdated, tt.outdatedNotReady, 1, task.Failed)
+
+			current, outdated = m.rollService(s, current, outdated)
+
+			if len(current) != tt.wantCurrent || len(outdated) != tt.wantOutdated {
+				t.Errorf("left %d current and %d outdated tasks, want %d and %d", len(current), len(outdated), tt.wantCurrent, tt.wantOutdated)
+			}
+			if started := m.Pending.Len(); started != tt.wantStarted {
+				t.Errorf("started %d tasks, want %d", started, tt.wantStarted)
+			}
+			retired := 0
+			for _, tk := range m.GetTasks() {
+				if tk.Reason == task.ReasonStopped || (tk.RestartPolicy.Policy == task.RestartNever && tk.Revision == 1) {
+					retired++
+				}
+			}
+			if retired != tt.wantRetired {
+				t.Errorf("retired %d tasks, want %d", retired, tt.wantRetired)
+			}
+		})
+	}
+}
+
+func TestUndoService(t *testing.T) {
+	tests := []struct {
+		name string
+		// images are the templates the service is created and updated with,
+		// and undos the revisions undone to in turn, zero for the previous one
+		images []string
+		undos  []int
+		// wantImage is the template the service ends up with, under
+		// wantRevision with cause wantCause
+		wantImage    string
+		wantRevision int
+		wantCause    string
+		wantErr      bool
+	}{
+		{
+			name:   "previous revision",
+			images: []string{"nginx:1", "nginx:2", "nginx:3"}, undos: []int{0},
+			wantImage: "nginx:2", wantRevision: 4, wantCause: "rolled back to revision 2",
+		},
+		{
+			name:   "undo after a rollback goes back to the revision rolled back from",
+			images: []string{"nginx:1", "nginx:2", "nginx:3"}, undos: []int{0, 0},
+			wantImage: "nginx:3", wantRevision: 5, wantCause: "rolled back to revision 3",
+		},
+		{
+			name:   "given revision",
+			images: []string{"nginx:1", "nginx:2", "nginx:3"}, undos: []int{1},
+			wantImage: "nginx:1", wantRevision: 4, wantCause: "rolled back to revision 1",
+		},
+		{
+			name:   "given revision after a rollback",
+			images: []string{"nginx:1", "nginx:2"}, undos: []int{1, 2},
+			wantImage: "nginx:2", wantRevision: 4, wantCause: "rolled back to revision 2",
+		},
+		{
+			name:   "no earlier revision",
+			images: []string{"nginx:1"}, undos: []int{0},
+			wantErr: true,
+		},
+		{
+			name:   "unknown revision",
+			images: []string{"nginx:1", "nginx:2"}, undos: []int{7},
+			wantErr: true,
+		},
+		{
+			name:   "current revision",
+			images: []string{"nginx:1", "nginx:2"}, undos: []int{2},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(nil, "roundrobin", "memory")
+			for i, image := range tt.images {
+				s := task.Service{Name: "web", Replicas: 1, Template: task.Task{Image: image}}
+				var err error
+				if i == 0 {
+					_, err = m.AddService(s, "alice")
+				} else {
+					_, err = m.UpdateService(s, "alice")
+				}
+				if err != nil {
+					t.Fatalf("setting up service: %v", err)
+				}
+			}
+
+			var s *task.Service
+			var err error
+			for _, to := range tt.undos {
+				s, err = m.UndoService("web", to, "bob")
+				if err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UndoService error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if s.Template.Image != tt.wantImage || s.Revision != tt.wantRevision {
+				t.Errorf("service has %s at revision %d, want %s at %d", s.Template.Image, s.Revision, tt.wantImage, tt.wantRevision)
+			}
+			revisions := m.GetRevisions("web")
+			last := revisions[len(revisions)-1]
+			if last.Revision != tt.wantRevision || last.Template.Image != tt.wantImage || last.Cause != tt.wantCause || last.Submitter != "bob" {
+				t.Errorf("last revision is %d of %s, %q by %s, want %d of %s, %q by bob",
+					last.Revision, last.Template.Image, last.Cause, last.Submitter, tt.wantRevision, tt.wantImage, tt.wantCause)
+			}
+		})
+	}
+}
+
+// storeTask makes a task of a service's revision that started age ago and
+// stores it, as the manager would have.
+func storeTask(m *Manager, s *task.Service, revision int, state task.State, age time.Duration) *task.Task {
+	tk := &task.Task{
+		ID:        uuid.New(),
+		Name:      s.Name,
+		Service:   s.Name,
+		Revision:  revision,
+		State:     state,
+		StartTime: time.Now().UTC().Add(-age),
+	}
+	m.TaskDb.Put(tk.ID.String(), tk)
+
+	return tk
+}
+
+func TestCanaryService(t *testing.T) {
+	tests := []struct {
+		name                      string
+		canaries                  int
+		autoPromote, promoted     bool
+		current, currentPending   int
+		wantCurrent, wantOutdated int
+		wantStarted, wantRetired  int
+		wantPromoted              bool
+	}{
+		{
+			name:        "starts one canary by default",
+			wantCurrent: 1, wantOutdated: 3, wantStarted: 1,
+		},
+		{
+			name:        "starts the canaries asked for",
+			canaries:    2,
+			wantCurrent: 2, wantOutdated: 3, wantStarted: 2,
+		},
+		{
+			name:        "waits to be promoted by hand",
+			current:     1,
+			wantCurrent: 1, wantOutdated: 3,
+		},
+		{
+			name:           "waits for the canary to be ready",
+			autoPromote:    true,
+			currentPending: 1,
+			wantCurrent:    1, wantOutdated: 3,
+		},
+		{
+			name:        "promotes itself once the canary is ready",
+			autoPromote: true, current: 1,
+			wantCurrent: 2, wantOutdated: 2, wantStarted: 1, wantRetired: 1, wantPromoted: true,
+		},
+		{
+			name:     "promoted canary rolls the rest over",
+			promoted: true, current: 1,
+			wantCurrent: 2, wantOutdated: 2, wantStarted: 1, wantRetired: 1, wantPromoted: true,
+		},
+		{
+			name:        "surplus canaries are stopped",
+			current:     3,
+			wantCurrent: 1, wantOutdated: 3, wantRetired: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(nil, "roundrobin", "memory")
+			s := &task.Service{
+				Name:     "web",
+				Replicas: 3,
+				Strategy: task.Strategy{Type: task.Canary, CanaryReplicas: tt.canaries, AutoPromote: tt.autoPromote},
+				Template: task.Task{Image: "nginx"},
+				Revision: 2,
+				Rollout:  task.Rollout{Promoted: tt.promoted},
+			}
+
+			var current, outdated []*task.Task
+			for i := 0; i < tt.current; i++ {
+				current = append(current, storeTask(m, s, 2, task.Running, time.Minute))
+			}
+			for i := 0; i < tt.currentPending; i++ {
+				current = append(current, storeTask(m, s, 2, task.Pending, 0))
+			}
+			for i := 0; i < 3; i++ {
+				outdated = append(outdated, storeTask(m, s, 1, task.Running, time.Hour))
+			}
+
+			current, outdated = m.canaryService(s, current, outdated)
+
+			if len(current) != tt.wantCurrent || len(outdated) != tt.wantOutdated {
+				t.Errorf("left %d current and %d outdated tasks, want %d and %d", len(current), len(outdated), tt.wantCurrent, tt.wantOutdated)
+			}
+			if started := m.Pending.Len(); started != tt.wantStarted {
+				t.Errorf("started %d tasks, want %d", started, tt.wantStarted)
+			}
+			retired := 0
+			for _, tk := range m.GetTasks() {
+				if tk.Reason == task.ReasonStopped {
+					retired++
+				}
+			}
+			if retired != tt.wantRetired {
+				t.Errorf("retired %d tasks, want %d", retired, tt.wantRetired)
+			}
+			if s.Rollout.Promoted != tt.wantPromoted {
+				t.Errorf("promoted = %v, want %v", s.Rollout.Promoted, tt.wantPromoted)
+			}
+		})
+	}
+}
+
+func TestBlueGreenService(t *testing.T) {
+	tests := []struct {
+		name                      string
+		promoted                  bool
+		current, currentPending   int
+		wantCurrent, wantOutdated int
+		wantStarted, wantRetired  int
+		wantActive                int
+	}{
+		{
+			name:        "starts a full set of new tasks",
+			wantCurrent: 2, wantOutdated: 2, wantStarted: 2, wantActive: 1,
+		},
+		{
+			name:        "waits to be promoted by hand",
+			current:     2,
+			wantCurrent: 2, wantOutdated: 2, wantActive: 1,
+		},
+		{
+			name:     "waits for every new task to be ready",
+			promoted: true, current: 1, currentPending: 1,
+			wantCurrent: 2, wantOutdated: 2, wantActive: 1,
+		},
+		{
+			name:     "switches traffic once promoted",
+			promoted: true, current: 2,
+			wantCurrent: 2, wantOutdated: 0, wantRetired: 2, wantActive: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(nil, "roundrobin", "memory")
+			s := &task.Service{
+				Name:           "web",
+				Replicas:       2,
+				Strategy:       task.Strategy{Type: task.BlueGreen},
+				Template:       task.Task{Image: "nginx"},
+				Revision:       2,
+				ActiveRevision: 1,
+				Rollout:        task.Rollout{Promoted: tt.promoted},
+			}
+
+			var current, outdated []*task.Task
+			for i := 0; i < tt.current; i++ {
+				current = append(current, storeTask(m, s, 2, task.Running, time.Minute))
+			}
+			for i := 0; i < tt.currentPending; i++ {
+				current = append(current, storeTask(m, s, 2, task.Pending, 0))
+			}
+			for i := 0; i < 2; i++ {
+				outdated = append(outdated, storeTask(m, s, 1, task.Running, time.Hour))
+			}
+
+			current, outdated = m.blueGreenService(s, current, outdated)
+
+			if len(current) != tt.wantCurrent || len(outdated) != tt.wantOutdated {
+				t.Errorf("left %d current and %d outdated tasks, want %d and %d", len(current), len(outdated), tt.wantCurrent, tt.wantOutdated)
+			}
+			if started := m.Pending.Len(); started != tt.wantStarted {
+				t.Errorf("started %d tasks, want %d", started, tt.wantStarted)
+			}
+			retired := 0
+			for _, tk := range m.GetTasks() {
+				if tk.Reason == task.ReasonStopped {
+					retired++
+				}
+			}
+			if retired != tt.wantRetired {
+				t.Errorf("retired %d tasks, want %d", retired, tt.wantRetired)
+			}
+			if s.ActiveRevision != tt.wantActive {
+				t.Errorf("active revision = %d, want %d", s.ActiveRevision, tt.wantActive)
+			}
+		})
+	}
+}
+
+func TestAwaitPromotion(t *testing.T) {
+	tests := []struct {
+		name         string
+		ready        int
+		autoPromote  bool
+		promoteDelay uint
+		promoted     bool
+		// readySince is how long the new tasks have been ready, zero if
+		// they were not before
+		readySince   time.Duration
+		want         bool
+		wantPromoted bool
+		wantReady    bool
+	}{
+		{
+			name:  "not every task ready",
+			ready: 1, promoted: true, readySince: time.Hour,
+			want: false, wantPromoted: true, wantReady: false,
+		},
+		{
+			name:  "waits to be promoted by hand",
+			ready: 2,
+			want:  false, wantReady: true,
+		},
+		{
+			name:  "promoted by hand",
+			ready: 2, promoted: true,
+			want: true, wantPromoted: true, wantReady: true,
+		},
+		{
+			name:  "promoted automatically without a delay",
+			ready: 2, autoPromote: true,
+			want: true, wantPromoted: true, wantReady: true,
+		},
+		{
+			name:  "waits out the delay",
+			ready: 2, autoPromote: true, promoteDelay: 60, readySince: 30 * time.Second,
+			want: false, wantReady: true,
+		},
+		{
+			name:  "promoted automatically after the delay",
+			ready: 2, autoPromote: true, promoteDelay: 60, readySince: 2 * time.Minute,
+			want: true, wantPromoted: true, wantReady: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &task.Service{
+				Name:     "web",
+				Strategy: task.Strategy{AutoPromote: tt.autoPromote, PromoteDelay: tt.promoteDelay},
+				Revision: 2,
+				Rollout:  task.Rollout{Promoted: tt.promoted},
+			}
+			if tt.readySince != 0 {
+				s.Rollout.ReadyTime = time.Now().UTC().Add(-tt.readySince)
+			}
+			current := []*task.Task{{State: task.Pending}, {State: task.Pending}}
+			for _, tk := range current[:tt.ready] {
+				tk.State = task.Running
+			}
+
+			if got := awaitPromotion(s, current, 2); got != tt.want {
+				t.Errorf("awaitPromotion = %v, want %v", got, tt.want)
+			}
+			if s.Rollout.Promoted != tt.wantPromoted {
+				t.Errorf("promoted = %v, want %v", s.Rollout.Promoted, tt.wantPromoted)
+			}
+			if s.Rollout.ReadyTime.IsZero() == tt.wantReady {
+				t.Errorf("ready time = %v, want it set %v", s.Rollout.ReadyTime, tt.wantReady)
+			}
+		})
+	}
+}
+
+func TestGetEndpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		// wantRevisions are the revisions of the endpoints returned
+		wantRevisions []int
+	}{
+		{name: "rolling update sends traffic to every revision", strategy: task.RollingUpdate, wantRevisions: []int{1, 2}},
+		{name: "canary sends traffic to every revision", strategy: task.Canary, wantRevisions: []int{1, 2}},
+		{name: "blue/green sends traffic to the active revision", strategy: task.BlueGreen, wantRevisions: []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(nil, "roundrobin", "memory")
+			s, err := m.AddService(task.Service{
+				Name:     "web",
+				Replicas: 1,
+				Strategy: task.Strategy{Type: tt.strategy},
+				Template: task.Task{Image: "nginx:1"},
+			}, "alice")
+			if err != nil {
+				t.Fatalf("AddService: %v", err)
+			}
+			s, err = m.UpdateService(task.Service{
+				Name:     "web",
+				Replicas: 1,
+				Strategy: task.Strategy{Type: tt.strategy},
+				Template: task.Task{Image: "nginx:2"},
+			}, "alice")
+			if err != nil {
+				t.Fatalf("UpdateService: %v", err)
+			}
+
+			old := storeTask(m, s, 1, task.Running, time.Hour)
+			m.assignTask("worker1:5556", old)
+			storeTask(m, s, 2, task.Running, time.Minute)
+			storeTask(m, s, 2, task.Pending, 0)
+			storeTask(m, s, 1, task.Failed, time.Hour)
+
+			endpoints, err := m.GetEndpoints("web")
+			if err != nil {
+				t.Fatalf("GetEndpoints: %v", err)
+			}
+			var revisions []int
+			for _, e := range endpoints {
+				revisions = append(revisions, e.Revision)
+				want := ""
+				if e.TaskID == old.ID {
+					want = "worker1"
+				}
+				if e.Address != want {
+					t.Errorf("endpoint of revision %d at %q, want %q", e.Revision, e.Address, want)
+				}
+			}
+			sort.Ints(revisions)
+			if !reflect.DeepEqual(revisions, tt.wantRevisions) {
+				t.Errorf("endpoints of revisions %v, want %v", revisions, tt.wantRevisions)
+			}
+		})
+	}
+}
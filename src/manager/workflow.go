@@ -0,0 +1,301 @@
+package manager
+
+import (
+	"bufio"
+	"context"
+	"cube/task"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AddWorkflow stores a new workflow for ProcessWorkflows to start.
+func (m *Manager) AddWorkflow(wf task.Workflow) (*task.Workflow, error) {
+	if wf.ID == uuid.Nil {
+		wf.ID = uuid.New()
+	}
+	if wf.FailurePolicy == "" {
+		wf.FailurePolicy = task.FailFast
+	}
+	err := wf.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.WorkflowDb.Get(wf.ID.String()); err == nil {
+		return nil, fmt.Errorf("workflow %v already exists", wf.ID)
+	}
+
+	wf.State = task.WorkflowPending
+	wf.Message = ""
+	for i := range wf.Steps {
+		s := &wf.Steps[i]
+		s.State = task.StepWaiting
+		s.TaskID = uuid.Nil
+		s.Attempts = 0
+		s.Outputs = nil
+		s.Message = ""
+	}
+	err = m.WorkflowDb.Put(wf.ID.String(), &wf)
+	if err != nil {
+		return nil, fmt.Errorf("error storing workflow %v: %w", wf.ID, err)
+	}
+
+	return &wf, nil
+}
+
+func (m *Manager) GetWorkflows() []*task.Workflow {
+	workflowList, err := m.WorkflowDb.List()
+	if err != nil {
+		log.Printf("error getting list of workflows: %v\n", err)
+		return nil
+	}
+
+	return workflowList.([]*task.Workflow)
+}
+
+func (m *Manager) GetWorkflow(id uuid.UUID) (*task.Workflow, error) {
+	result, err := m.WorkflowDb.Get(id.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*task.Workflow), nil
+}
+
+// StopWorkflow fails a workflow that has not finished, stopping its running
+// steps and skipping those yet to run.
+func (m *Manager) StopWorkflow(id uuid.UUID) (*task.Workflow, error) {
+	m.workflowMu.Lock()
+	defer m.workflowMu.Unlock()
+	wf, err := m.GetWorkflow(id)
+	if err != nil {
+		return nil, err
+	}
+	if wf.Finished() {
+		return nil, fmt.Errorf("workflow %v has already finished", id)
+	}
+
+	m.finishWorkflow(wf, task.WorkflowFailed, "stopped")
+	err = m.WorkflowDb.Put(wf.ID.String(), wf)
+	if err != nil {
+		return nil, fmt.Errorf("error updating workflow %v: %w", wf.ID, err)
+	}
+
+	return wf, nil
+}
+
+func (m *Manager) ProcessWorkflows() {
+	for {
+		log.Println("Processing workflows")
+		m.processWorkflows()
+		log.Println("Sleeping for 10 seconds")
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func (m *Manager) processWorkflows() {
+	for _, wf := range m.GetWorkflows() {
+		if wf.Finished() {
+			continue
+		}
+
+		m.workflowMu.Lock()
+		// Sync the workflow as it is now, in case it was stopped since the
+		// list was read.
+		wf, err := m.GetWorkflow(wf.ID)
+		if err == nil && !wf.Finished() {
+			m.syncWorkflow(wf)
+			err = m.WorkflowDb.Put(wf.ID.String(), wf)
+			if err != nil {
+				log.Printf("Error updating workflow %s in database: %v", wf.ID.String(), err)
+			}
+		}
+		m.workflowMu.Unlock()
+	}
+}
+
+// syncWorkflow records how the workflow's running steps have ended, then
+// starts each waiting step whose dependencies have all succeeded and skips
+// each one with a dependency that did not.
+func (m *Manager) syncWorkflow(wf *task.Workflow) {
+	if wf.State == task.WorkflowPending {
+		wf.State = task.WorkflowRunning
+		wf.StartTime = time.Now().UTC()
+	}
+
+	for i := range wf.Steps {
+		s := &wf.Steps[i]
+		if s.State == task.StepRunning {
+			m.syncStep(wf, s)
+		}
+		if s.State == task.StepFailed && wf.FailurePolicy != task.Continue {
+			m.finishWorkflow(wf, task.WorkflowFailed, fmt.Sprintf("step %s failed", s.Name))
+			return
+		}
+	}
+
+	// Skipping a step can skip the steps after it, so go round until
+	// nothing more is skipped.
+	for changed := true; changed; {
+		changed = false
+	steps:
+		for i := range wf.Steps {
+			s := &wf.Steps[i]
+			if s.State != task.StepWaiting {
+				continue
+			}
+			for _, d := range s.DependsOn {
+				switch wf.Step(d).State {
+				case task.StepSucceeded:
+				case task.StepFailed, task.StepSkipped:
+					s.State = task.StepSkipped
+					s.Message = fmt.Sprintf("step %s did not succeed", d)
+					changed = true
+					continue steps
+				default:
+					continue steps
+				}
+			}
+			m.startStep(wf, s)
+		}
+	}
+
+	var failed, skipped int
+	for _, s := range wf.Steps {
+		switch s.State {
+		case task.StepFailed:
+			failed++
+		case task.StepSkipped:
+			skipped++
+		case task.StepWaiting, task.StepRunning:
+			return
+		}
+	}
+	if failed > 0 {
+		m.finishWorkflow(wf, task.WorkflowFailed, fmt.Sprintf("%d steps failed and %d were skipped", failed, skipped))
+		return
+	}
+	m.finishWorkflow(wf, task.WorkflowSucceeded, fmt.Sprintf("%d steps succeeded", len(wf.Steps)))
+}
+
+// syncStep checks the task of a running step. A step that succeeded has
+// its outputs read from the task's logs; one that failed is run again if it
+// has retries left.
+func (m *Manager) syncStep(wf *task.Workflow, s *task.Step) {
+	result, err := m.TaskDb.Get(s.TaskID.String())
+	if err != nil {
+		log.Printf("Error getting task %v of workflow %s: %v\n", s.TaskID, wf.Name, err)
+		return
+	}
+	t := result.(*task.Task)
+
+	switch {
+	case t.State == task.Completed && t.Reason != task.ReasonStopped:
+		lines, err := m.outputLines(t)
+		if err != nil {
+			// The outputs are needed before the steps after this one can
+			// start, so try again next time.
+			s.Message = fmt.Sprintf("error reading outputs: %v", err)
+			log.Printf("Error reading outputs of step %s of workflow %s: %v\n", s.Name, wf.Name, err)
+			return
+		}
+		s.Outputs, err = task.ParseOutputs(lines)
+		s.Message = ""
+		if err != nil {
+			s.Message = err.Error()
+		}
+		s.State = task.StepSucceeded
+		log.Printf("Workflow %s step %s succeeded\n", wf.Name, s.Name)
+	case t.State == task.Completed || t.State == task.Failed:
+		msg := fmt.Sprintf("attempt %d failed", s.Attempts)
+		switch {
+		case t.Reason != "":
+			msg += ": " + t.Reason
+		case t.ExitCode != 0:
+			msg += fmt.Sprintf(": exit code %d", t.ExitCode)
+		}
+		if t.TerminationMessage != "" && !strings.EqualFold(t.TerminationMessage, t.Reason) {
+			msg += ": " + t.TerminationMessage
+		}
+		// A step whose task was stopped by hand is not retried, just as
+		// the task would not be restarted.
+		if s.Attempts <= s.Retries && t.Reason != task.ReasonStopped {
+			log.Printf("Workflow %s step %s %s, retrying\n", wf.Name, s.Name, msg)
+			m.startStep(wf, s)
+			s.Message = msg
+			return
+		}
+		s.State = task.StepFailed
+		s.Message = msg
+		log.Printf("Workflow %s step %s %s\n", wf.Name, s.Name, msg)
+	}
+}
+
+// startStep submits a task for the step's next attempt.
+func (m *Manager) startStep(wf *task.Workflow, s *task.Step) {
+	s.Attempts++
+	t := wf.NewTask(s)
+	s.TaskID = t.ID
+	s.State = task.StepRunning
+	s.Message = ""
+	m.submitTask(t)
+	log.Printf("Workflow %s started step %s as task %v\n", wf.Name, s.Name, t.ID)
+}
+
+// finishWorkflow records how the workflow ended. Steps still running are
+// stopped and failed, and steps still waiting are skipped.
+func (m *Manager) finishWorkflow(wf *task.Workflow, state task.WorkflowState, msg string) {
+	log.Printf("Workflow %s %v: %s\n", wf.Name, state, msg)
+	wf.State = state
+	wf.Message = msg
+	wf.CompletionTime = time.Now().UTC()
+
+	for i := range wf.Steps {
+		s := &wf.Steps[i]
+		switch s.State {
+		case task.StepWaiting:
+			s.State = task.StepSkipped
+			s.Message = "workflow " + msg
+		case task.StepRunning:
+			result, err := m.TaskDb.Get(s.TaskID.String())
+			if err == nil {
+				m.cancelTask(result.(*task.Task), "workflow finished")
+			}
+			s.State = task.StepFailed
+			s.Message = "stopped, workflow " + msg
+		}
+	}
+}
+
+// outputLines reads the lines of a task's logs that set outputs.
+func (m *Manager) outputLines(t *task.Task) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := m.GetTaskLogs(ctx, t.ID, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("worker returned %s", resp.Status)
+	}
+
+	var lines []string
+	r := bufio.NewReader(resp.Body)
+	for {
+		line, err := r.ReadString('\n')
+		if strings.HasPrefix(line, task.OutputPrefix) {
+			lines = append(lines, line)
+		}
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -8,9 +8,11 @@ import (
 	"time"
 )
 
+// Scheduler picks a node for a task. Candidates are selected and scored on
+// the task's resources, which for a task group are summed over its members.
 type Scheduler interface {
-	SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node
-	Score(t task.Task, nodes []*node.Node) map[string]float64
+	SelectCandidateNodes(r task.Resources, nodes []*node.Node) []*node.Node
+	Score(r task.Resources, nodes []*node.Node) map[string]float64
 	Pick(scores map[string]float64, candidates []*node.Node) *node.Node
 }
 
@@ -19,10 +21,10 @@ type RoundRobin struct {
 	LastWorker int
 }
 
-func (r *RoundRobin) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+func (r *RoundRobin) SelectCandidateNodes(res task.Resources, nodes []*node.Node) []*node.Node {
 	return nodes
 }
-func (r *RoundRobin) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+func (r *RoundRobin) Score(res task.Resources, nodes []*node.Node) map[string]float64 {
 	nodeScores := make(map[string]float64)
 	var newWorker int
 
@@ -71,16 +73,16 @@ const (
 	LIEB = 1.53960071783900203869
 )
 
-func (e *Epvm) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+func (e *Epvm) SelectCandidateNodes(r task.Resources, nodes []*node.Node) []*node.Node {
 	var candidates []*node.Node
 	for node := range nodes {
-		if checkDisk(t, nodes[node].DiskAllocated) {
+		if checkDisk(r, nodes[node].DiskAllocated) {
 			candidates = append(candidates, nodes[node])
 		}
 	}
 	return candidates
 }
-func (e *Epvm) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+func (e *Epvm) Score(r task.Resources, nodes []*node.Node) map[string]float64 {
 	nodeScores := make(map[string]float64)
 	maxJobs := 4.0
 
@@ -95,7 +97,8 @@ func (e *Epvm) Score(t task.Task, nodes []*node.Node) map[string]float64 {
 		memoryAllocated := float64(node.Stats.MemUsedKb()) + float64(node.MemoryAllocated)
 		memoryPercentAllocated := memoryAllocated / float64(node.Memory)
 
-		newMemPercent := (calculateLoad(memoryAllocated+float64(t.Memory/1000), float64(node.Memory)))
+		// Node memory is reported in KiB, task memory is requested in MiB.
+		newMemPercent := (calculateLoad(memoryAllocated+float64(r.Memory*1024), float64(node.Memory)))
 
 		memCost := math.Pow(LIEB, newMemPercent) +
 			math.Pow(LIEB, (float64(node.TaskCount+1))/maxJobs) -
@@ -173,6 +176,6 @@ func calculateCpuUsage(node *node.Node) (*float64, error) {
 func calculateLoad(usage float64, capacity float64) float64 {
 	return usage / capacity
 }
-func checkDisk(t task.Task, diskAvailable int64) bool {
-	return t.Disk <= diskAvailable
+func checkDisk(r task.Resources, diskAvailable int64) bool {
+	return r.Disk <= diskAvailable
 }
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// InMemoryStore keeps values of type T in memory. Put takes a *T and List
+// returns a []*T. It is safe for concurrent use, and like BoltStore it
+// stores and hands out copies of its values.
+type InMemoryStore[T any] struct {
+	mu   sync.RWMutex
+	name string
+	Db   map[string]*T
+}
+
+// NewInMemoryStore returns an empty store. The name describes the values in
+// error messages.
+func NewInMemoryStore[T any](name string) *InMemoryStore[T] {
+	return &InMemoryStore[T]{
+		name: name,
+		Db:   make(map[string]*T),
+	}
+}
+func (i *InMemoryStore[T]) Put(key string, value interface{}) error {
+	v, ok := value.(*T)
+	if !ok {
+		return fmt.Errorf("value %v is not a %s", value, i.name)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	c := *v
+	i.Db[key] = &c
+	return nil
+}
+func (i *InMemoryStore[T]) Get(key string) (interface{}, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	v, ok := i.Db[key]
+	if !ok {
+		return nil, fmt.Errorf("%s with key %s does not exist", i.name, key)
+	}
+
+	c := *v
+	return &c, nil
+}
+func (i *InMemoryStore[T]) List() (interface{}, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	var values []*T
+	for _, v := range i.Db {
+		c := *v
+		values = append(values, &c)
+	}
+
+	return values, nil
+}
+func (i *InMemoryStore[T]) Count() (int, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return len(i.Db), nil
+}
+func (i *InMemoryStore[T]) Delete(key string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.Db[key]; !ok {
+		return fmt.Errorf("%s with key %s does not exist", i.name, key)
+	}
+
+	delete(i.Db, key)
+	return nil
+}
+
+// BoltStore keeps values of type T as JSON in a boltdb bucket. Like
+// InMemoryStore, Put takes a *T and List returns a []*T.
+type BoltStore[T any] struct {
+	Db       *bolt.DB
+	DbFile   string
+	FileMode os.FileMode
+	Bucket   string
+	name     string
+}
+
+func NewBoltStore[T any](name string, file string, mode os.FileMode, bucket string) (*BoltStore[T], error) {
+	db, err := bolt.Open(file, mode, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %v", file)
+	}
+
+	s := BoltStore[T]{
+		DbFile:   file,
+		FileMode: mode,
+		Db:       db,
+		Bucket:   bucket,
+		name:     name,
+	}
+
+	err = s.Db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create bucket %s: %w", bucket, err)
+	}
+
+	return &s, nil
+}
+func (s *BoltStore[T]) Close() {
+	err := s.Db.Close()
+	if err != nil {
+		log.Printf("Error closing %s database: %v", s.name, err)
+	}
+}
+func (s *BoltStore[T]) Count() (int, error) {
+	count := 0
+	err := s.Db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket([]byte(s.Bucket)).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return count, nil
+}
+func (s *BoltStore[T]) Put(key string, value interface{}) error {
+	v, ok := value.(*T)
+	if !ok {
+		return fmt.Errorf("value %v is not a %s", value, s.name)
+	}
+
+	return s.Db.Update(func(tx *bolt.Tx) error {
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket([]byte(s.Bucket)).Put([]byte(key), buf)
+	})
+}
+func (s *BoltStore[T]) Get(key string) (interface{}, error) {
+	var v T
+	err := s.Db.View(func(tx *bolt.Tx) error {
+		buf := tx.Bucket([]byte(s.Bucket)).Get([]byte(key))
+		if buf == nil {
+			return fmt.Errorf("%s %v not found", s.name, key)
+		}
+
+		return json.Unmarshal(buf, &v)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+func (s *BoltStore[T]) List() (interface{}, error) {
+	var values []*T
+	err := s.Db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(s.Bucket)).ForEach(func(k, buf []byte) error {
+			var v T
+			err := json.Unmarshal(buf, &v)
+			if err != nil {
+				return err
+			}
+			values = append(values, &v)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+func (s *BoltStore[T]) Delete(key string) error {
+	return s.Db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.Bucket))
+		if b.Get([]byte(key)) == nil {
+			return fmt.Errorf("%s %v not found", s.name, key)
+		}
+
+		return b.Delete([]byte(key))
+	})
+}
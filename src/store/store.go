@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 
 	"github.com/boltdb/bolt"
 )
@@ -15,9 +16,14 @@ type Store interface {
 	Get(key string) (interface{}, error)
 	List() (interface{}, error)
 	Count() (int, error)
+	Delete(key string) error
 }
 
+// InMemoryTaskStore is safe for concurrent use. Like TaskStore it keeps its
+// own copy of each task and hands out copies, so a caller changing a task it
+// got does not race with other readers; changes take effect on Put.
 type InMemoryTaskStore struct {
+	mu sync.RWMutex
 	Db map[string]*task.Task
 }
 
@@ -32,30 +38,60 @@ func (i *InMemoryTaskStore) Put(key string, value interface{}) error {
 		return fmt.Errorf("value %v is not a task.Task type", value)
 	}
 
-	i.Db[key] = t
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.Db[key] = copyTask(t)
 	return nil
 }
 func (i *InMemoryTaskStore) Get(key string) (interface{}, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	t, ok := i.Db[key]
 	if !ok {
 		return nil, fmt.Errorf("task with key %s does not exist", key)
 	}
 
-	return t, nil
+	return copyTask(t), nil
 }
 func (i *InMemoryTaskStore) List() (interface{}, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	var tasks []*task.Task
 	for _, t := range i.Db {
-		tasks = append(tasks, t)
+		tasks = append(tasks, copyTask(t))
 	}
 
 	return tasks, nil
 }
 func (i *InMemoryTaskStore) Count() (int, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	return len(i.Db), nil
 }
+func (i *InMemoryTaskStore) Delete(key string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.Db[key]; !ok {
+		return fmt.Errorf("task with key %s does not exist", key)
+	}
+
+	delete(i.Db, key)
+	return nil
+}
 
+// copyTask copies a task along with the members and init containers that
+// the worker updates in place.
+func copyTask(t *task.Task) *task.Task {
+	c := *t
+	c.Members = append([]task.Member(nil), t.Members...)
+	c.InitContainers = append([]task.Task(nil), t.InitContainers...)
+	return &c
+}
+
+// InMemoryTaskEventStore is safe for concurrent use and, like
+// InMemoryTaskStore, hands out copies of its events.
 type InMemoryTaskEventStore struct {
+	mu sync.RWMutex
 	Db map[string]*task.TaskEvent
 }
 
@@ -70,28 +106,49 @@ func (i *InMemoryTaskEventStore) Put(key string, value interface{}) error {
 		return fmt.Errorf("value %v is not a task.TaskEvent type", value)
 	}
 
-	i.Db[key] = e
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	c := *e
+	i.Db[key] = &c
 	return nil
 }
 func (i *InMemoryTaskEventStore) Get(key string) (interface{}, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	e, ok := i.Db[key]
 	if !ok {
 		return nil, fmt.Errorf("task event with key %s does not exist", key)
 	}
 
-	return e, nil
+	c := *e
+	return &c, nil
 }
 func (i *InMemoryTaskEventStore) List() (interface{}, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	var events []*task.TaskEvent
 	for _, e := range i.Db {
-		events = append(events, e)
+		c := *e
+		events = append(events, &c)
 	}
 
 	return events, nil
 }
 func (i *InMemoryTaskEventStore) Count() (int, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	return len(i.Db), nil
 }
+func (i *InMemoryTaskEventStore) Delete(key string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.Db[key]; !ok {
+		return fmt.Errorf("task event with key %s does not exist", key)
+	}
+
+	delete(i.Db, key)
+	return nil
+}
 
 type TaskStore struct {
 	Db       *bolt.DB
@@ -226,6 +283,17 @@ func (t *TaskStore) List() (interface{}, error) {
 	return tasks, nil
 }
 
+func (t *TaskStore) Delete(key string) error {
+	return t.Db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(t.Bucket))
+		if b.Get([]byte(key)) == nil {
+			return fmt.Errorf("task %v not found", key)
+		}
+
+		return b.Delete([]byte(key))
+	})
+}
+
 type EventStore struct {
 	DbFile   string
 	FileMode os.FileMode
@@ -347,3 +415,13 @@ func (e *EventStore) List() (interface{}, error) {
 
 	return events, nil
 }
+func (e *EventStore) Delete(key string) error {
+	return e.Db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(e.Bucket))
+		if b.Get([]byte(key)) == nil {
+			return fmt.Errorf("event %v not found", key)
+		}
+
+		return b.Delete([]byte(key))
+	})
+}
@@ -0,0 +1,140 @@
+// Package stream multiplexes the standard streams of an exec session over a
+// single upgraded HTTP connection.
+//
+// Every message is a frame with an 8 byte header, the same layout Docker and
+// Podman use for attached streams: one byte naming the stream, three zero
+// bytes and the payload length as a big-endian uint32.
+package stream
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Protocol is the value of the Upgrade header used to start an exec session.
+const Protocol = "cube-exec"
+
+const (
+	// Stdin carries input from the client. An empty frame closes stdin.
+	Stdin byte = iota
+	Stdout
+	Stderr
+	// Exit is the last frame the server sends; its payload is the exit code
+	// as a big-endian int32.
+	Exit
+	// Resize is sent by the client when its terminal changes size; its
+	// payload is the height and width as big-endian uint16s.
+	Resize
+)
+
+const (
+	headerSize = 8
+	// maxFrameSize bounds the payload a reader accepts.
+	maxFrameSize = 1 << 20
+)
+
+// Mux writes frames to an underlying writer. It is safe for concurrent use.
+type Mux struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewMux(w io.Writer) *Mux {
+	return &Mux{w: w}
+}
+
+func (m *Mux) WriteFrame(stream byte, payload []byte) error {
+	header := make([]byte, headerSize)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.w.Write(header)
+	if err != nil {
+		return err
+	}
+	_, err = m.w.Write(payload)
+	return err
+}
+
+// Writer returns an io.Writer that sends everything written to it as frames
+// of the given stream.
+func (m *Mux) Writer(stream byte) io.Writer {
+	return streamWriter{mux: m, stream: stream}
+}
+
+func (m *Mux) WriteExit(code int) error {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(int32(code)))
+	return m.WriteFrame(Exit, payload)
+}
+
+func (m *Mux) WriteResize(height uint16, width uint16) error {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint16(payload, height)
+	binary.BigEndian.PutUint16(payload[2:], width)
+	return m.WriteFrame(Resize, payload)
+}
+
+type streamWriter struct {
+	mux    *Mux
+	stream byte
+}
+
+func (s streamWriter) Write(p []byte) (int, error) {
+	// Empty stdin frames mean EOF, so never send an empty write.
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	err := s.mux.WriteFrame(s.stream, p)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// ReadFrame reads the next frame from r.
+func ReadFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, headerSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[4:])
+	if size > maxFrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes exceeds the %d byte limit", size, maxFrameSize)
+	}
+
+	payload := make([]byte, size)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], payload, nil
+}
+
+// ExitCode decodes the payload of an Exit frame.
+func ExitCode(payload []byte) int {
+	if len(payload) != 4 {
+		return -1
+	}
+
+	return int(int32(binary.BigEndian.Uint32(payload)))
+}
+
+// Size decodes the payload of a Resize frame into height and width.
+func Size(payload []byte) (uint16, uint16) {
+	if len(payload) != 4 {
+		return 0, 0
+	}
+
+	return binary.BigEndian.Uint16(payload), binary.BigEndian.Uint16(payload[2:])
+}
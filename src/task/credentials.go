@@ -0,0 +1,47 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/distribution/reference"
+)
+
+// Credentials used to log in to a container registry.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// CredentialStore maps a registry host, e.g. docker.io or
+// registry.example.com:5000, to the credentials used to pull from it.
+type CredentialStore map[string]Credentials
+
+// LoadCredentials reads a CredentialStore from a JSON file of the form
+// {"registry.example.com": {"Username": "...", "Password": "..."}}.
+func LoadCredentials(filename string) (CredentialStore, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read credentials file %s: %w", filename, err)
+	}
+
+	var s CredentialStore
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse credentials file %s: %w", filename, err)
+	}
+
+	return s, nil
+}
+
+// Lookup returns the credentials for the registry image is pulled from.
+func (s CredentialStore) Lookup(image string) (Credentials, bool) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return Credentials{}, false
+	}
+
+	c, ok := s[reference.Domain(named)]
+	return c, ok
+}
@@ -0,0 +1,156 @@
+package task
+
+import (
+	"cube/cron"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Concurrency policies, deciding what a cron job does when a run is due
+// while an earlier one is still active.
+const (
+	ConcurrencyAllow   = "Allow"
+	ConcurrencyForbid  = "Forbid"
+	ConcurrencyReplace = "Replace"
+)
+
+// DefaultCronHistoryLimit is how many finished runs a cron job keeps when
+// it does not set HistoryLimit.
+const DefaultCronHistoryLimit = 3
+
+// CronJob runs a task or a job from a template on a cron schedule. Exactly
+// one of TaskTemplate and JobTemplate must be set.
+type CronJob struct {
+	ID   uuid.UUID
+	Name string
+	// Schedule is a cron schedule (see the cron package), read in TimeZone,
+	// an IANA name such as "Europe/London", or UTC if that is not set
+	Schedule string
+	TimeZone string
+	// ConcurrencyPolicy is ConcurrencyAllow (the default),
+	// ConcurrencyForbid or ConcurrencyReplace
+	ConcurrencyPolicy string
+	// StartingDeadline is how many seconds late a run may start, such as
+	// after the manager was down, before it is skipped. Zero means no limit.
+	StartingDeadline uint
+	// HistoryLimit is how many finished runs are kept in Runs
+	HistoryLimit int
+	TaskTemplate *Task
+	JobTemplate  *Job
+
+	// The rest is the cron job's status, kept by the manager.
+	CreationTime     time.Time
+	LastScheduleTime time.Time
+	NextScheduleTime time.Time
+	// Runs lists the runs that are active and the latest finished ones,
+	// oldest first
+	Runs []CronRun
+}
+
+// CronRun is a task or job a cron job has created.
+type CronRun struct {
+	// ID is that of the task or job
+	ID            uuid.UUID
+	ScheduledTime time.Time
+	Finished      bool
+}
+
+// Validate checks the cron job can be scheduled.
+func (c *CronJob) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("cron job has no name")
+	}
+	if _, err := cron.Parse(c.Schedule); err != nil {
+		return fmt.Errorf("cron job %s: %w", c.Name, err)
+	}
+	if _, err := c.Location(); err != nil {
+		return fmt.Errorf("cron job %s: %w", c.Name, err)
+	}
+	switch c.ConcurrencyPolicy {
+	case "", ConcurrencyAllow, ConcurrencyForbid, ConcurrencyReplace:
+	default:
+		return fmt.Errorf("cron job %s: unknown concurrency policy %q", c.Name, c.ConcurrencyPolicy)
+	}
+	if c.HistoryLimit < 0 {
+		return fmt.Errorf("cron job %s: HistoryLimit must not be negative", c.Name)
+	}
+	if (c.TaskTemplate == nil) == (c.JobTemplate == nil) {
+		return fmt.Errorf("cron job %s needs exactly one of TaskTemplate or JobTemplate", c.Name)
+	}
+	if c.JobTemplate != nil {
+		j := *c.JobTemplate
+		j.Name = c.Name
+		return j.Validate()
+	}
+	if err := c.TaskTemplate.Validate(); err != nil {
+		return fmt.Errorf("cron job %s: %w", c.Name, err)
+	}
+
+	return nil
+}
+
+// Location returns the time zone the schedule is read in.
+func (c *CronJob) Location() (*time.Location, error) {
+	if c.TimeZone == "" {
+		return time.UTC, nil
+	}
+
+	return time.LoadLocation(c.TimeZone)
+}
+
+// NextRun returns the first time after t that the cron job is due.
+func (c *CronJob) NextRun(t time.Time) (time.Time, error) {
+	s, err := cron.Parse(c.Schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := c.Location()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return s.Next(t.In(loc)), nil
+}
+
+// ActiveRuns returns the runs that have not finished.
+func (c *CronJob) ActiveRuns() []CronRun {
+	var active []CronRun
+	for _, r := range c.Runs {
+		if !r.Finished {
+			active = append(active, r)
+		}
+	}
+
+	return active
+}
+
+// TrimHistory drops the oldest finished runs beyond HistoryLimit and returns
+// them, oldest first, so their tasks or jobs can be deleted.
+func (c *CronJob) TrimHistory() []CronRun {
+	limit := c.HistoryLimit
+	if limit == 0 {
+		limit = DefaultCronHistoryLimit
+	}
+
+	finished := 0
+	for _, r := range c.Runs {
+		if r.Finished {
+			finished++
+		}
+	}
+
+	var runs, trimmed []CronRun
+	for _, r := range c.Runs {
+		if r.Finished && finished > limit {
+			finished--
+			trimmed = append(trimmed, r)
+			continue
+		}
+		runs = append(runs, r)
+	}
+	c.Runs = runs
+
+	return trimmed
+}
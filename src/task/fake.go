@@ -0,0 +1,277 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/podman/v5/libpod/define"
+	"github.com/google/uuid"
+)
+
+// Fake is an in-memory Runtime that never touches a container engine. It
+// lets a worker and manager run end to end in CI.
+type Fake struct {
+	mu         sync.Mutex
+	Containers map[string]*define.InspectContainerData
+	// Images is the fake image cache, keyed by normalized image name
+	Images map[string]*ImageSummary
+	// Pods maps a pod ID to its members' container IDs
+	Pods map[string][]string
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		Containers: make(map[string]*define.InspectContainerData),
+		Images:     make(map[string]*ImageSummary),
+		Pods:       make(map[string][]string),
+	}
+}
+
+func (f *Fake) PullImage(image string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pullImage(image)
+	return nil
+}
+
+func (f *Fake) pullImage(image string) {
+	name := NormalizeImage(image)
+	if _, ok := f.Images[name]; ok {
+		return
+	}
+	f.Images[name] = &ImageSummary{
+		ID:      uuid.New().String(),
+		Names:   []string{name},
+		Created: time.Now().UTC(),
+	}
+}
+
+func (f *Fake) ListImages() ([]ImageSummary, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var summaries []ImageSummary
+	for _, i := range f.Images {
+		summaries = append(summaries, *i)
+	}
+
+	return summaries, nil
+}
+
+func (f *Fake) RemoveImage(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for name, i := range f.Images {
+		if i.ID == id {
+			delete(f.Images, name)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no such image %s", id)
+}
+
+func (f *Fake) Run(c *Config) ContainerResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pullImage(c.Image)
+	id := uuid.New().String()
+	f.Containers[id] = &define.InspectContainerData{
+		ID:        id,
+		Name:      c.Name,
+		Image:     c.Image,
+		ImageName: c.Image,
+		Created:   time.Now().UTC(),
+		State: &define.InspectContainerState{
+			Status:    "running",
+			Running:   true,
+			StartedAt: time.Now().UTC(),
+		},
+		Config: &define.InspectContainerConfig{Labels: c.Labels},
+		HostConfig: &define.InspectContainerHostConfig{
+			CpuShares: c.Cpu,
+			Memory:    c.Memory * 1024 * 1024,
+		},
+		NetworkSettings: &define.InspectNetworkSettings{},
+	}
+	log.Printf("Fake container %s:%s started", c.Name, id)
+
+	return ContainerResult{ContainerId: id, Action: "start", Result: "success"}
+}
+
+func (f *Fake) Stop(id string) ContainerResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.Containers[id]; !ok {
+		return ContainerResult{Error: fmt.Errorf("no such container %s", id)}
+	}
+	delete(f.Containers, id)
+
+	return ContainerResult{Action: "stop", Result: "success"}
+}
+
+func (f *Fake) RunPod(c *PodConfig) PodResult {
+	result := PodResult{PodID: uuid.New().String()}
+	for _, m := range c.Members {
+		r := f.Run(m)
+		if r.Error != nil {
+			return PodResult{Error: r.Error}
+		}
+		result.ContainerIDs = append(result.ContainerIDs, r.ContainerId)
+	}
+
+	f.mu.Lock()
+	f.Pods[result.PodID] = result.ContainerIDs
+	f.mu.Unlock()
+
+	return result
+}
+
+func (f *Fake) StopPod(id string) ContainerResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ids, ok := f.Pods[id]
+	if !ok {
+		return ContainerResult{Error: fmt.Errorf("no such pod %s", id)}
+	}
+	for _, cid := range ids {
+		if c, ok := f.Containers[cid]; ok && c.State.Running {
+			c.State.Status = "exited"
+			c.State.Running = false
+			c.State.FinishedAt = time.Now().UTC()
+		}
+	}
+
+	return ContainerResult{Action: "stop", Result: "success"}
+}
+
+func (f *Fake) RemovePod(id string) ContainerResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ids, ok := f.Pods[id]
+	if !ok {
+		return ContainerResult{Error: fmt.Errorf("no such pod %s", id)}
+	}
+	for _, cid := range ids {
+		delete(f.Containers, cid)
+	}
+	delete(f.Pods, id)
+
+	return ContainerResult{Action: "remove", Result: "success"}
+}
+
+func (f *Fake) Inspect(id string) InspectResponse {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.Containers[id]
+	if !ok {
+		return InspectResponse{Error: fmt.Errorf("no such container %s", id)}
+	}
+	data := *c
+	state := *c.State
+	data.State = &state
+
+	return InspectResponse{Container: &data}
+}
+
+func (f *Fake) List() ([]ContainerSummary, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var summaries []ContainerSummary
+	for _, c := range f.Containers {
+		summaries = append(summaries, ContainerSummary{
+			ID:     c.ID,
+			Name:   c.Name,
+			Image:  c.Image,
+			Status: c.State.Status,
+			Labels: c.Config.Labels,
+		})
+	}
+
+	return summaries, nil
+}
+
+// Wait returns at once: fake containers exit successfully as soon as they
+// are waited on.
+func (f *Fake) Wait(ctx context.Context, id string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.Containers[id]
+	if !ok {
+		return -1, fmt.Errorf("no such container %s", id)
+	}
+	if c.State.Running {
+		c.State.Status = "exited"
+		c.State.Running = false
+		c.State.FinishedAt = time.Now().UTC()
+	}
+
+	return int(c.State.ExitCode), nil
+}
+
+// Logs writes a single line describing the fake container. When following,
+// it blocks until the container is stopped or the context is cancelled.
+func (f *Fake) Logs(ctx context.Context, id string, opts LogOptions, w io.Writer) error {
+	f.mu.Lock()
+	c, ok := f.Containers[id]
+	f.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no such container %s", id)
+	}
+
+	if opts.Tail != 0 {
+		_, err := fmt.Fprintf(w, "fake container %s running image %s\n", c.Name, c.Image)
+		if err != nil {
+			return err
+		}
+	}
+	if !opts.Follow {
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second):
+		}
+
+		f.mu.Lock()
+		_, ok := f.Containers[id]
+		f.mu.Unlock()
+		if !ok {
+			return nil
+		}
+	}
+}
+
+// Exec echoes the command it was asked to run and exits with 0.
+func (f *Fake) Exec(ctx context.Context, id string, opts ExecOptions) (int, error) {
+	f.mu.Lock()
+	_, ok := f.Containers[id]
+	f.mu.Unlock()
+	if !ok {
+		return -1, fmt.Errorf("no such container %s", id)
+	}
+
+	_, err := fmt.Fprintf(opts.Stdout, "fake exec: %s\n", strings.Join(opts.Cmd, " "))
+	if err != nil {
+		return -1, err
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,118 @@
+package task
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobState int
+
+const (
+	JobPending JobState = iota
+	JobRunning
+	JobSucceeded
+	JobFailed
+)
+
+func (s JobState) String() string {
+	switch s {
+	case JobPending:
+		return "Pending"
+	case JobRunning:
+		return "Running"
+	case JobSucceeded:
+		return "Succeeded"
+	case JobFailed:
+		return "Failed"
+	}
+
+	return fmt.Sprintf("JobState(%d)", int(s))
+}
+
+// Defaults for a job's counts when they are not set.
+const (
+	DefaultJobCompletions  = 1
+	DefaultJobParallelism  = 1
+	DefaultJobBackoffLimit = 6
+)
+
+// Job runs tasks to completion rather than keeping them running. It
+// succeeds once Completions of its tasks have exited 0, running at most
+// Parallelism at a time, and fails once more than BackoffLimit have failed.
+// Zero Completions and Parallelism mean the defaults.
+type Job struct {
+	ID   uuid.UUID
+	Name string
+	// Template is the task each of the job's tasks is created from
+	Template    Task
+	Completions int
+	Parallelism int
+	// BackoffLimit is a pointer so that zero, failing the job on its first
+	// failed task, can be told apart from leaving it unset for the default
+	BackoffLimit *int
+
+	// The rest is the job's status, kept by the manager.
+	State JobState
+	// Tasks lists every task the job has created, in order
+	Tasks          []uuid.UUID
+	Active         int
+	Succeeded      int
+	Failed         int
+	StartTime      time.Time
+	CompletionTime time.Time
+	Message        string
+}
+
+// SetDefaults fills in the counts the job leaves unset.
+func (j *Job) SetDefaults() {
+	if j.Completions == 0 {
+		j.Completions = DefaultJobCompletions
+	}
+	if j.Parallelism == 0 {
+		j.Parallelism = DefaultJobParallelism
+	}
+	if j.BackoffLimit == nil {
+		limit := DefaultJobBackoffLimit
+		j.BackoffLimit = &limit
+	}
+}
+
+// Validate checks the job can be run.
+func (j *Job) Validate() error {
+	if j.Name == "" {
+		return fmt.Errorf("job has no name")
+	}
+	if j.Completions < 0 || j.Parallelism < 0 || (j.BackoffLimit != nil && *j.BackoffLimit < 0) {
+		return fmt.Errorf("job %s: Completions, Parallelism and BackoffLimit must not be negative", j.Name)
+	}
+	if j.Template.Image == "" && len(j.Template.Command) == 0 && len(j.Template.Members) == 0 {
+		return fmt.Errorf("job %s: template has no image or command", j.Name)
+	}
+	if err := j.Template.Validate(); err != nil {
+		return fmt.Errorf("job %s: %w", j.Name, err)
+	}
+
+	return nil
+}
+
+// Finished reports whether the job has succeeded or failed.
+func (j *Job) Finished() bool {
+	return j.State == JobSucceeded || j.State == JobFailed
+}
+
+// NewTask creates the job's next task from its template. Task names carry
+// part of the job's ID, so resubmitting a job does not reuse the container
+// names of an earlier one.
+func (j *Job) NewTask() Task {
+	t := j.Template
+	t.ID = uuid.New()
+	t.Name = fmt.Sprintf("%s-%s-%d", j.Name, j.ID.String()[:8], len(j.Tasks))
+	t.JobID = j.ID
+	t.State = Pending
+	// A job replaces its failed tasks rather than restarting them.
+	t.RestartPolicy = RestartPolicy{Policy: RestartNever}
+
+	return t
+}
@@ -0,0 +1,622 @@
+package task
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"context"
+
+	"github.com/containers/podman/v5/pkg/api/handlers"
+	"github.com/containers/podman/v5/pkg/bindings"
+	"github.com/containers/podman/v5/pkg/bindings/containers"
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/containers/podman/v5/pkg/bindings/pods"
+	"github.com/containers/podman/v5/pkg/bindings/system"
+	entitiesTypes "github.com/containers/podman/v5/pkg/domain/entities/types"
+	"github.com/containers/podman/v5/pkg/specgen"
+	dockerContainer "github.com/docker/docker/api/types/container"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	rootfulPodmanSocket = "/run/podman/podman.sock"
+	podmanAPIVersion    = "5.0.0"
+)
+
+// Podman is the Runtime backed by a Podman service socket. A single
+// connection is opened when it is created and shared by every call.
+type Podman struct {
+	Conn     context.Context
+	Endpoint string
+	Rootless bool
+	// Credentials used to pull images from private registries
+	Credentials CredentialStore
+}
+
+// NewPodman connects to the Podman service at endpoint. An empty endpoint
+// is resolved with PodmanEndpoint.
+func NewPodman(endpoint string) (*Podman, error) {
+	if endpoint == "" {
+		endpoint = PodmanEndpoint()
+	}
+
+	conn, err := bindings.NewConnection(context.Background(), endpoint)
+	if err != nil {
+		log.Printf("Error creating Podman connection to %s: %s\n", endpoint, err)
+		return nil, err
+	}
+
+	info, err := system.Info(conn, nil)
+	if err != nil {
+		log.Printf("Error getting Podman info from %s: %s\n", endpoint, err)
+		return nil, err
+	}
+	log.Printf("Connected to Podman %s at %s (rootless: %t)", info.Version.Version, endpoint, info.Host.Security.Rootless)
+
+	return &Podman{Conn: conn, Endpoint: endpoint, Rootless: info.Host.Security.Rootless}, nil
+}
+
+// PodmanEndpoint returns the rootless socket under $XDG_RUNTIME_DIR when it
+// exists, falling back to the rootful socket otherwise.
+func PodmanEndpoint() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		socket := filepath.Join(dir, "podman", "podman.sock")
+		if _, err := os.Stat(socket); err == nil {
+			return "unix://" + socket
+		}
+	}
+
+	return "unix://" + rootfulPodmanSocket
+}
+
+func (p *Podman) Inspect(containerID string) InspectResponse {
+	resp, err := containers.Inspect(p.Conn, containerID, nil)
+	if err != nil {
+		log.Printf("Error inspecting container: %s\n", err)
+		return InspectResponse{Error: err}
+	}
+
+	return InspectResponse{Container: resp}
+}
+
+func (p *Podman) Run(c *Config) ContainerResult {
+	err := p.pullImage(c)
+	if err != nil {
+		log.Printf("Error pulling image %s: %v\n", c.Image, err)
+		return ContainerResult{Error: err}
+	}
+
+	s, err := containerSpec(c)
+	if err != nil {
+		log.Printf("Error preparing container %s: %v", c.Name, err)
+		return ContainerResult{Error: err}
+	}
+	s.PortMappings = c.ExposedPorts
+
+	createResponse, err := containers.CreateWithSpec(p.Conn, s, nil)
+	if err != nil {
+		log.Printf("Error creating container %s: %v", c.Name, err)
+		return ContainerResult{Error: err}
+	}
+	log.Printf("Container %s:%s created", c.Name, createResponse.ID)
+
+	if err := containers.Start(p.Conn, createResponse.ID, nil); err != nil {
+		log.Printf("Error starting container %s:%s -> %v", c.Name, createResponse.ID, err)
+		return ContainerResult{Error: err}
+	}
+	log.Printf("Container %s:%s started", c.Name, createResponse.ID)
+
+	return ContainerResult{ContainerId: createResponse.ID, Action: "start", Result: "success"}
+}
+
+// containerSpec builds the spec for a container from its config, leaving out
+// port mappings, which belong to the pod when the container is in one.
+func containerSpec(c *Config) (*specgen.SpecGenerator, error) {
+	s := specgen.NewSpecGenerator(c.Image, false)
+	s.ResourceLimits = resourceLimits(c)
+	s.Name = c.Name
+	s.Env = c.Env
+	s.Entrypoint = c.Entrypoint
+	s.Command = c.Cmd
+	s.WorkDir = c.WorkingDir
+	s.Labels = c.Labels
+	stopSignal, err := c.Signal()
+	if err != nil {
+		return nil, err
+	}
+	stopTimeout := uint(c.GracePeriod().Seconds())
+	s.StopSignal = &stopSignal
+	s.StopTimeout = &stopTimeout
+	setSecurityContext(s, c.SecurityContext)
+	s.Volumes, s.Mounts, err = mounts(c.Mounts)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing mounts: %w", err)
+	}
+
+	return s, nil
+}
+
+// RunPod creates a pod owning the network namespace and ports, then starts
+// each member's container in it. If any member cannot be created the whole
+// pod is removed again.
+func (p *Podman) RunPod(c *PodConfig) PodResult {
+	ps := entitiesTypes.PodSpec{PodSpecGen: *specgen.NewPodSpecGenerator()}
+	ps.PodSpecGen.Name = c.Name
+	ps.PodSpecGen.Labels = c.Labels
+	ps.PodSpecGen.PortMappings = c.ExposedPorts
+
+	report, err := pods.CreatePodFromSpec(p.Conn, &ps)
+	if err != nil {
+		log.Printf("Error creating pod %s: %v\n", c.Name, err)
+		return PodResult{Error: err}
+	}
+	log.Printf("Pod %s:%s created", c.Name, report.Id)
+
+	result := PodResult{PodID: report.Id}
+	for _, m := range c.Members {
+		err := p.pullImage(m)
+		if err != nil {
+			result.Error = fmt.Errorf("error pulling image %s for member %s: %w", m.Image, m.Name, err)
+			break
+		}
+
+		s, err := containerSpec(m)
+		if err != nil {
+			result.Error = fmt.Errorf("error preparing member %s: %w", m.Name, err)
+			break
+		}
+		s.Pod = report.Id
+
+		createResponse, err := containers.CreateWithSpec(p.Conn, s, nil)
+		if err != nil {
+			result.Error = fmt.Errorf("error creating member %s: %w", m.Name, err)
+			break
+		}
+		result.ContainerIDs = append(result.ContainerIDs, createResponse.ID)
+	}
+
+	if result.Error == nil {
+		_, result.Error = pods.Start(p.Conn, report.Id, nil)
+	}
+	if result.Error != nil {
+		log.Printf("Error starting pod %s:%s: %v\n", c.Name, report.Id, result.Error)
+		p.RemovePod(report.Id)
+		return PodResult{Error: result.Error}
+	}
+	log.Printf("Pod %s:%s started", c.Name, report.Id)
+
+	return result
+}
+
+// StopPod stops every container in the pod but keeps them, so their exit
+// status and logs can still be read.
+func (p *Podman) StopPod(id string) ContainerResult {
+	log.Printf("Attempting to stop pod %v", id)
+	_, err := pods.Stop(p.Conn, id, nil)
+	if err != nil {
+		log.Printf("Error stopping pod %s: %v\n", id, err)
+		return ContainerResult{Error: err}
+	}
+
+	return ContainerResult{Action: "stop", Result: "success"}
+}
+
+func (p *Podman) RemovePod(id string) ContainerResult {
+	log.Printf("Attempting to remove pod %v", id)
+	_, err := pods.Remove(p.Conn, id, new(pods.RemoveOptions).WithForce(true))
+	if err != nil {
+		log.Printf("Error removing pod %s: %v\n", id, err)
+		return ContainerResult{Error: err}
+	}
+
+	return ContainerResult{Action: "remove", Result: "success"}
+}
+
+func setSecurityContext(s *specgen.SpecGenerator, sc SecurityContext) {
+	s.User = sc.User
+	if sc.Group != "" {
+		s.User = sc.User + ":" + sc.Group
+	}
+	s.CapAdd = sc.CapAdd
+	s.CapDrop = sc.CapDrop
+	s.NoNewPrivileges = &sc.NoNewPrivileges
+	s.ReadOnlyFilesystem = &sc.ReadOnlyRootfs
+	s.Privileged = &sc.Privileged
+}
+
+// pullImage pulls the config's image as its pull policy requires, logging in
+// with the registry's credentials when there are any.
+func (p *Podman) pullImage(c *Config) error {
+	policy := c.PullPolicy()
+	switch policy {
+	case PullAlways:
+	case PullIfNotPresent, PullNever:
+		exists, err := images.Exists(p.Conn, c.Image, nil)
+		if err != nil {
+			return fmt.Errorf("error checking for image %s: %w", c.Image, err)
+		}
+		if exists {
+			log.Printf("Image %s is present, not pulling it (policy %s)\n", c.Image, policy)
+			return nil
+		}
+		if policy == PullNever {
+			return fmt.Errorf("image %s is not present and the pull policy is %s", c.Image, policy)
+		}
+	default:
+		return fmt.Errorf("unknown image pull policy %q", policy)
+	}
+
+	opts := new(images.PullOptions)
+	if creds, ok := p.Credentials.Lookup(c.Image); ok {
+		opts.WithUsername(creds.Username).WithPassword(creds.Password)
+	}
+
+	irp, err := images.Pull(p.Conn, c.Image, opts)
+	if err != nil {
+		return err
+	}
+	log.Printf("%s", strings.Join(irp, "\n"))
+
+	return nil
+}
+
+func (p *Podman) PullImage(image string) error {
+	return p.pullImage(&Config{Image: image, ImagePullPolicy: PullAlways})
+}
+
+func (p *Podman) ListImages() ([]ImageSummary, error) {
+	list, err := images.List(p.Conn, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var summaries []ImageSummary
+	for _, i := range list {
+		summaries = append(summaries, ImageSummary{
+			ID:      i.ID,
+			Names:   i.RepoTags,
+			Size:    i.Size,
+			Created: time.Unix(i.Created, 0).UTC(),
+		})
+	}
+
+	return summaries, nil
+}
+
+func (p *Podman) RemoveImage(id string) error {
+	_, errs := images.Remove(p.Conn, []string{id}, nil)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	return nil
+}
+
+func (p *Podman) Stop(id string) ContainerResult {
+	log.Printf("Attempting to stop container %v", id)
+	err := containers.Stop(p.Conn, id, nil)
+	if err != nil {
+		log.Printf("Error stopping container %s: %v\n", id, err)
+		return ContainerResult{Error: err}
+	}
+
+	// Anonymous volumes go with the container, named volumes are kept.
+	_, err = containers.Remove(p.Conn, id, new(containers.RemoveOptions).WithVolumes(true))
+	if err != nil {
+		log.Printf("Error removing container %s: %v\n", id, err)
+		return ContainerResult{Error: err}
+	}
+
+	return ContainerResult{Action: "stop", Result: "success", Error: nil}
+}
+
+func (p *Podman) List() ([]ContainerSummary, error) {
+	list, err := containers.List(p.Conn, new(containers.ListOptions).WithAll(true))
+	if err != nil {
+		log.Printf("Error listing containers: %v\n", err)
+		return nil, err
+	}
+
+	var summaries []ContainerSummary
+	for _, c := range list {
+		var name string
+		if len(c.Names) > 0 {
+			name = c.Names[0]
+		}
+		summaries = append(summaries, ContainerSummary{
+			ID:     c.ID,
+			Name:   name,
+			Image:  c.Image,
+			Status: c.State,
+			Labels: c.Labels,
+		})
+	}
+
+	return summaries, nil
+}
+
+// resourceLimits converts the task's CPU shares and memory in MiB into the
+// OCI resource limits Podman applies to the container. Zero means no limit.
+func resourceLimits(c *Config) *specs.LinuxResources {
+	limits := &specs.LinuxResources{}
+	if c.Cpu > 0 {
+		shares := c.Cpu
+		limits.CPU = &specs.LinuxCPU{Shares: &shares}
+	}
+	if c.Memory > 0 {
+		bytes := c.Memory * 1024 * 1024
+		limits.Memory = &specs.LinuxMemory{Limit: &bytes}
+	}
+
+	return limits
+}
+
+// mounts splits a task's mounts into the named volumes and OCI mounts of a
+// container spec. Podman creates named volumes that don't exist yet, and an
+// anonymous volume for a volume mount without a name.
+func mounts(taskMounts []Mount) ([]*specgen.NamedVolume, []specs.Mount, error) {
+	var volumes []*specgen.NamedVolume
+	var ociMounts []specs.Mount
+
+	for _, m := range taskMounts {
+		var options []string
+		if m.ReadOnly {
+			options = append(options, "ro")
+		}
+
+		switch m.Type {
+		case MountVolume:
+			volumes = append(volumes, &specgen.NamedVolume{
+				Name:    m.Source,
+				Dest:    m.Target,
+				Options: options,
+			})
+		case MountBind:
+			ociMounts = append(ociMounts, specs.Mount{
+				Type:        MountBind,
+				Source:      m.Source,
+				Destination: m.Target,
+				Options:     append(options, "rbind"),
+			})
+		case MountTmpfs:
+			ociMounts = append(ociMounts, specs.Mount{
+				Type:        MountTmpfs,
+				Source:      MountTmpfs,
+				Destination: m.Target,
+				Options:     options,
+			})
+		default:
+			return nil, nil, fmt.Errorf("unknown mount type %q for %s", m.Type, m.Target)
+		}
+	}
+
+	return volumes, ociMounts, nil
+}
+
+func (p *Podman) Wait(ctx context.Context, id string) (int, error) {
+	waitCtx, cancel := context.WithCancel(p.Conn)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	code, err := containers.Wait(waitCtx, id, nil)
+	if err != nil {
+		return -1, err
+	}
+
+	return int(code), nil
+}
+
+func (p *Podman) Logs(ctx context.Context, id string, opts LogOptions, w io.Writer) error {
+	options := new(containers.LogOptions).WithFollow(opts.Follow).WithStdout(true).WithStderr(true)
+	if opts.Tail >= 0 {
+		options = options.WithTail(strconv.Itoa(opts.Tail))
+	}
+	if !opts.Since.IsZero() {
+		options = options.WithSince(opts.Since.Format(time.RFC3339Nano))
+	}
+
+	// The connection context carries the client, so derive from it and
+	// cancel when the caller's context is done.
+	logCtx, cancel := context.WithCancel(p.Conn)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	lines := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- containers.Logs(logCtx, id, options, lines, lines)
+	}()
+
+	var writeErr error
+	for {
+		select {
+		case line := <-lines:
+			if writeErr != nil {
+				continue
+			}
+			_, writeErr = io.WriteString(w, line)
+			if writeErr != nil {
+				cancel()
+			}
+		case err := <-errc:
+			if writeErr != nil {
+				return writeErr
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Exec runs a command in the container. Starting the session is done over a
+// dedicated socket rather than with the bindings, which attach to the
+// worker's own terminal and replace the transport of the shared connection.
+func (p *Podman) Exec(ctx context.Context, id string, opts ExecOptions) (int, error) {
+	config := &handlers.ExecCreateConfig{
+		ExecOptions: dockerContainer.ExecOptions{
+			Cmd:          opts.Cmd,
+			Tty:          opts.Tty,
+			AttachStdin:  opts.Stdin != nil,
+			AttachStdout: true,
+			AttachStderr: !opts.Tty,
+		},
+	}
+	if opts.Tty && opts.Size.Height > 0 {
+		config.ConsoleSize = &[2]uint{uint(opts.Size.Height), uint(opts.Size.Width)}
+	}
+
+	sessionID, err := containers.ExecCreate(p.Conn, id, config)
+	if err != nil {
+		log.Printf("Error creating exec session in container %s: %v\n", id, err)
+		return -1, err
+	}
+	defer func() {
+		err := containers.ExecRemove(p.Conn, sessionID, new(containers.ExecRemoveOptions).WithForce(true))
+		if err != nil {
+			log.Printf("Error removing exec session %s: %v\n", sessionID, err)
+		}
+	}()
+
+	err = p.startExec(ctx, sessionID, opts)
+	if err != nil {
+		log.Printf("Error running exec session %s: %v\n", sessionID, err)
+		return -1, err
+	}
+
+	session, err := containers.ExecInspect(p.Conn, sessionID, nil)
+	if err != nil {
+		log.Printf("Error inspecting exec session %s: %v\n", sessionID, err)
+		return -1, err
+	}
+
+	return session.ExitCode, nil
+}
+
+func (p *Podman) startExec(ctx context.Context, sessionID string, opts ExecOptions) error {
+	u, err := url.Parse(p.Endpoint)
+	if err != nil {
+		return err
+	}
+	var network, address string
+	switch u.Scheme {
+	case "unix":
+		network, address = "unix", u.Path
+	case "tcp":
+		network, address = "tcp", u.Host
+	default:
+		return fmt.Errorf("exec is not supported over %s connections", u.Scheme)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"Detach": false,
+		"Tty":    opts.Tty,
+		"h":      opts.Size.Height,
+		"w":      opts.Size.Width,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("http://d/v%s/libpod/exec/%s/start", podmanAPIVersion, sessionID), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	err = req.Write(conn)
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusSwitchingProtocols {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("exec start returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if opts.Resize != nil {
+		go func() {
+			for size := range opts.Resize {
+				resize := new(containers.ResizeExecTTYOptions).WithHeight(int(size.Height)).WithWidth(int(size.Width))
+				err := containers.ResizeExecTTY(p.Conn, sessionID, resize)
+				if err != nil {
+					log.Printf("Error resizing exec session %s: %v\n", sessionID, err)
+				}
+			}
+		}()
+	}
+
+	if opts.Stdin != nil {
+		go func() {
+			_, err := io.Copy(conn, opts.Stdin)
+			if err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Printf("Error writing stdin to exec session %s: %v\n", sessionID, err)
+			}
+			if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+			}
+		}()
+	}
+
+	if opts.Tty {
+		_, err := io.Copy(opts.Stdout, br)
+		if err != nil && ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+			return err
+		}
+		return nil
+	}
+
+	buffer := make([]byte, 1024)
+	for {
+		fd, l, err := containers.DemuxHeader(br, buffer)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		frame, err := containers.DemuxFrame(br, buffer, l)
+		if err != nil {
+			return err
+		}
+
+		out := opts.Stdout
+		if fd == 2 {
+			out = opts.Stderr
+		}
+		_, err = out.Write(frame)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package task
+
+import (
+	"fmt"
+	"time"
+)
+
+// Defaults for a probe's timing and thresholds when it does not set them.
+const (
+	DefaultProbeInterval         = 10 * time.Second
+	DefaultProbeTimeout          = 1 * time.Second
+	DefaultProbeSuccessThreshold = 1
+	DefaultProbeFailureThreshold = 3
+)
+
+// Probe checks on a running task. Exactly one of HTTPGet, TCPSocket and Exec
+// must be set. Durations are in seconds, with zero meaning the default.
+type Probe struct {
+	HTTPGet   *HTTPGetAction
+	TCPSocket *TCPSocketAction
+	// Exec runs a command in the task's container, passing if it exits 0
+	Exec []string
+	// InitialDelay is how long after the task starts to wait before the
+	// first probe
+	InitialDelay uint
+	Interval     uint
+	Timeout      uint
+	// SuccessThreshold and FailureThreshold are how many probes in a row
+	// must pass or fail to change the probe's verdict
+	SuccessThreshold uint
+	FailureThreshold uint
+}
+
+// HTTPGetAction passes if a GET of Path on the container's Port returns
+// ExpectedStatus, or any 2xx or 3xx status if that is not set.
+type HTTPGetAction struct {
+	Path           string
+	Port           uint16
+	ExpectedStatus int
+}
+
+// TCPSocketAction passes if a TCP connection to the container's Port can be
+// opened.
+type TCPSocketAction struct {
+	Port uint16
+}
+
+// ProbeStatus is the worker's latest view of a probe.
+type ProbeStatus struct {
+	// Passing is the probe's verdict once its thresholds are applied
+	Passing bool
+	// Successes and Failures count the probes in a row with that result
+	Successes uint
+	Failures  uint
+	// Message is why the most recent failed probe failed
+	Message   string
+	LastProbe time.Time
+}
+
+// Validate checks that the probe has exactly one action.
+func (p *Probe) Validate() error {
+	n := 0
+	if p.HTTPGet != nil {
+		n++
+	}
+	if p.TCPSocket != nil {
+		n++
+	}
+	if len(p.Exec) > 0 {
+		n++
+	}
+	if n != 1 {
+		return fmt.Errorf("a probe needs exactly one of HTTPGet, TCPSocket or Exec, got %d", n)
+	}
+
+	return nil
+}
+
+// Period returns the time between probes.
+func (p *Probe) Period() time.Duration {
+	if p.Interval == 0 {
+		return DefaultProbeInterval
+	}
+
+	return time.Duration(p.Interval) * time.Second
+}
+
+// TimeoutDuration returns how long a single probe may take before it fails.
+func (p *Probe) TimeoutDuration() time.Duration {
+	if p.Timeout == 0 {
+		return DefaultProbeTimeout
+	}
+
+	return time.Duration(p.Timeout) * time.Second
+}
+
+// Thresholds returns how many probes in a row must pass and fail to change
+// the probe's verdict.
+func (p *Probe) Thresholds() (success uint, failure uint) {
+	success, failure = p.SuccessThreshold, p.FailureThreshold
+	if success == 0 {
+		success = DefaultProbeSuccessThreshold
+	}
+	if failure == 0 {
+		failure = DefaultProbeFailureThreshold
+	}
+
+	return success, failure
+}
+
+// Probes returns the task's liveness and readiness probes. A task that only
+// sets the older HealthCheck path gets an HTTP liveness probe of that path
+// on its first exposed port.
+func (t *Task) Probes() (liveness *Probe, readiness *Probe) {
+	liveness = t.LivenessProbe
+	if liveness == nil && t.HealthCheck != "" && len(t.ExposedPorts) > 0 {
+		liveness = &Probe{HTTPGet: &HTTPGetAction{Path: t.HealthCheck, Port: t.ExposedPorts[0].ContainerPort}}
+	}
+
+	return liveness, t.ReadinessProbe
+}
+
+// Ready reports whether a task can be sent traffic: it is running and, if
+// it has a readiness probe, the probe is passing.
+func (t *Task) Ready() bool {
+	if t.State != Running {
+		return false
+	}
+
+	return t.ReadinessProbe == nil || t.Readiness.Passing
+}
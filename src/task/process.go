@@ -0,0 +1,737 @@
+package task
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containers/podman/v5/libpod/define"
+	"github.com/google/uuid"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	processDataDir    = "/var/lib/cube/processes"
+	processCgroupRoot = "/sys/fs/cgroup/cube.slice"
+	// processKillTimeout bounds how long Stop waits for a killed process
+	processKillTimeout = 10 * time.Second
+
+	cgroup2SuperMagic = 0x63677270
+)
+
+// Process is a Runtime that runs a task's command directly on the host. Each
+// process is started inside its own cgroup v2 group carrying the task's CPU
+// and memory limits, and its stdout and stderr are written to files under
+// DataDir.
+type Process struct {
+	mu         sync.Mutex
+	DataDir    string
+	CgroupRoot string
+	Processes  map[string]*process
+}
+
+type process struct {
+	cmd    *exec.Cmd
+	config Config
+	dir    string
+	cgroup string
+	data   define.InspectContainerData
+	done   chan struct{}
+	// credential is also used for commands run by Exec
+	credential *syscall.Credential
+}
+
+func NewProcess(dataDir string, cgroupRoot string) *Process {
+	return &Process{
+		DataDir:    dataDir,
+		CgroupRoot: cgroupRoot,
+		Processes:  make(map[string]*process),
+	}
+}
+
+// Run starts the task's Command followed by its Args. When the task has no
+// Command, Image is taken to be the path of the executable to run.
+func (p *Process) Run(c *Config) ContainerResult {
+	if len(c.Mounts) > 0 {
+		return ContainerResult{Error: fmt.Errorf("the process runtime does not support mounts")}
+	}
+	if _, err := c.Signal(); err != nil {
+		return ContainerResult{Error: err}
+	}
+	credential, err := processCredential(c.SecurityContext)
+	if err != nil {
+		return ContainerResult{Error: err}
+	}
+
+	argv := append([]string{}, c.Entrypoint...)
+	if len(argv) == 0 {
+		argv = append(argv, c.Image)
+	}
+	argv = append(argv, c.Cmd...)
+
+	id := uuid.New().String()
+	dir := filepath.Join(p.DataDir, id)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("Error creating process directory %s: %v\n", dir, err)
+		return ContainerResult{Error: err}
+	}
+
+	stdout, err := os.Create(filepath.Join(dir, "stdout.log"))
+	if err != nil {
+		log.Printf("Error creating stdout log for %s: %v\n", c.Name, err)
+		return ContainerResult{Error: err}
+	}
+	stderr, err := os.Create(filepath.Join(dir, "stderr.log"))
+	if err != nil {
+		stdout.Close()
+		log.Printf("Error creating stderr log for %s: %v\n", c.Name, err)
+		return ContainerResult{Error: err}
+	}
+
+	cgroup, err := p.createCgroup(c, id)
+	if err != nil {
+		stdout.Close()
+		stderr.Close()
+		log.Printf("Error creating cgroup for %s: %v\n", c.Name, err)
+		return ContainerResult{Error: err}
+	}
+	cgroupFd, err := syscall.Open(cgroup, syscall.O_DIRECTORY|syscall.O_RDONLY, 0)
+	if err != nil {
+		stdout.Close()
+		stderr.Close()
+		removeCgroup(cgroup)
+		return ContainerResult{Error: fmt.Errorf("error opening cgroup %s: %w", cgroup, err)}
+	}
+	defer syscall.Close(cgroupFd)
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = envList(c.Env)
+	cmd.Dir = c.WorkingDir
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:     true,
+		UseCgroupFD: true,
+		CgroupFD:    cgroupFd,
+		Credential:  credential,
+	}
+
+	if err := cmd.Start(); err != nil {
+		stdout.Close()
+		stderr.Close()
+		removeCgroup(cgroup)
+		log.Printf("Error starting process %s: %v\n", c.Name, err)
+		return ContainerResult{Error: err}
+	}
+	log.Printf("Process %s:%s started with pid %d", c.Name, id, cmd.Process.Pid)
+
+	proc := &process{
+		cmd:    cmd,
+		config: *c,
+		dir:    dir,
+		cgroup: cgroup,
+		done:   make(chan struct{}),
+
+		credential: credential,
+		data: define.InspectContainerData{
+			ID:        id,
+			Name:      c.Name,
+			Path:      argv[0],
+			Args:      argv[1:],
+			Image:     c.Image,
+			ImageName: c.Image,
+			Created:   time.Now().UTC(),
+			State: &define.InspectContainerState{
+				Status:     "running",
+				Running:    true,
+				Pid:        cmd.Process.Pid,
+				StartedAt:  time.Now().UTC(),
+				CgroupPath: cgroup,
+			},
+			Config: &define.InspectContainerConfig{Labels: c.Labels},
+			HostConfig: &define.InspectContainerHostConfig{
+				CpuShares: c.Cpu,
+				Memory:    c.Memory * 1024 * 1024,
+			},
+		},
+	}
+
+	p.mu.Lock()
+	p.Processes[id] = proc
+	p.mu.Unlock()
+
+	go p.wait(proc, stdout, stderr)
+
+	return ContainerResult{ContainerId: id, Action: "start", Result: "success"}
+}
+
+func (p *Process) Stop(id string) ContainerResult {
+	p.mu.Lock()
+	proc, ok := p.Processes[id]
+	p.mu.Unlock()
+	if !ok {
+		return ContainerResult{Error: fmt.Errorf("no such process %s", id)}
+	}
+
+	select {
+	case <-proc.done:
+		// Exited on its own: wait has already released its cgroup, and its
+		// pid may since have been reused, so there is nothing to signal.
+	default:
+		err := p.terminate(id, proc)
+		if err != nil {
+			return ContainerResult{Error: err}
+		}
+	}
+
+	err := os.RemoveAll(proc.dir)
+	if err != nil {
+		log.Printf("Error removing process directory %s: %v\n", proc.dir, err)
+	}
+
+	p.mu.Lock()
+	delete(p.Processes, id)
+	p.mu.Unlock()
+
+	return ContainerResult{Action: "stop", Result: "success"}
+}
+
+// terminate signals a running process, waits for its grace period and then
+// kills whatever is left in its cgroup.
+func (p *Process) terminate(id string, proc *process) error {
+	// Run rejected configs with a bad signal, so this cannot fail.
+	sig, _ := proc.config.Signal()
+	grace := proc.config.GracePeriod()
+
+	log.Printf("Attempting to stop process %v with %s", id, unix.SignalName(sig))
+	err := syscall.Kill(-proc.cmd.Process.Pid, sig)
+	if err != nil && !errors.Is(err, syscall.ESRCH) {
+		log.Printf("Error signalling process %s: %v\n", id, err)
+	}
+
+	select {
+	case <-proc.done:
+		return nil
+	case <-time.After(grace):
+		log.Printf("Process %s did not exit after %v, killing it", id, grace)
+	}
+
+	err = killCgroup(proc.cgroup)
+	if err != nil {
+		log.Printf("Error killing cgroup %s: %v\n", proc.cgroup, err)
+		syscall.Kill(-proc.cmd.Process.Pid, syscall.SIGKILL)
+	}
+
+	select {
+	case <-proc.done:
+		return nil
+	case <-time.After(processKillTimeout):
+		return fmt.Errorf("process %s did not exit %v after being killed", id, processKillTimeout)
+	}
+}
+
+func (p *Process) Wait(ctx context.Context, id string) (int, error) {
+	p.mu.Lock()
+	proc, ok := p.Processes[id]
+	p.mu.Unlock()
+	if !ok {
+		return -1, fmt.Errorf("no such process %s", id)
+	}
+
+	select {
+	case <-proc.done:
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return int(proc.data.State.ExitCode), nil
+}
+
+func (p *Process) Inspect(id string) InspectResponse {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	proc, ok := p.Processes[id]
+	if !ok {
+		return InspectResponse{Error: fmt.Errorf("no such process %s", id)}
+	}
+	data := proc.data
+	state := *proc.data.State
+	data.State = &state
+
+	return InspectResponse{Container: &data}
+}
+
+func (p *Process) List() ([]ContainerSummary, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var summaries []ContainerSummary
+	for id, proc := range p.Processes {
+		summaries = append(summaries, ContainerSummary{
+			ID:     id,
+			Name:   proc.data.Name,
+			Image:  proc.data.Image,
+			Status: proc.data.State.Status,
+			Labels: proc.data.Config.Labels,
+		})
+	}
+
+	return summaries, nil
+}
+
+// Logs writes the process's stdout followed by its stderr. When following,
+// new output in either file is written as it appears, until the process
+// exits. Output is not timestamped, so Since only skips a file entirely when
+// it was last written before that time.
+func (p *Process) Logs(ctx context.Context, id string, opts LogOptions, w io.Writer) error {
+	p.mu.Lock()
+	proc, ok := p.Processes[id]
+	p.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no such process %s", id)
+	}
+
+	var files []*os.File
+	for _, name := range []string{"stdout.log", "stderr.log"} {
+		f, err := os.Open(filepath.Join(proc.dir, name))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		files = append(files, f)
+
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if !opts.Since.IsZero() && fi.ModTime().Before(opts.Since) {
+			_, err := f.Seek(0, io.SeekEnd)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = writeTail(f, opts.Tail, w)
+		if err != nil {
+			return err
+		}
+	}
+	if !opts.Follow {
+		return nil
+	}
+
+	for {
+		for _, f := range files {
+			_, err := io.Copy(w, f)
+			if err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-proc.done:
+			for _, f := range files {
+				_, err := io.Copy(w, f)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// Exec runs a command next to the task's process, in the same cgroup and
+// with the same environment and working directory.
+func (p *Process) Exec(ctx context.Context, id string, opts ExecOptions) (int, error) {
+	p.mu.Lock()
+	proc, ok := p.Processes[id]
+	running := ok && proc.data.State.Running
+	p.mu.Unlock()
+	if !ok {
+		return -1, fmt.Errorf("no such process %s", id)
+	}
+	if !running {
+		return -1, fmt.Errorf("process %s is not running", id)
+	}
+	if len(opts.Cmd) == 0 {
+		return -1, errors.New("no command given")
+	}
+
+	cgroupFd, err := syscall.Open(proc.cgroup, syscall.O_DIRECTORY|syscall.O_RDONLY, 0)
+	if err != nil {
+		return -1, fmt.Errorf("error opening cgroup %s: %w", proc.cgroup, err)
+	}
+	defer syscall.Close(cgroupFd)
+
+	cmd := exec.CommandContext(ctx, opts.Cmd[0], opts.Cmd[1:]...)
+	cmd.Env = envList(proc.config.Env)
+	cmd.Dir = proc.config.WorkingDir
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		UseCgroupFD: true,
+		CgroupFD:    cgroupFd,
+		Credential:  proc.credential,
+	}
+
+	if !opts.Tty {
+		cmd.Stdin = opts.Stdin
+		cmd.Stdout = opts.Stdout
+		cmd.Stderr = opts.Stderr
+		return exitCode(cmd.Run())
+	}
+
+	ptmx, tty, err := openPty()
+	if err != nil {
+		return -1, err
+	}
+	defer ptmx.Close()
+	setPtySize(ptmx, opts.Size)
+
+	cmd.Stdin = tty
+	cmd.Stdout = tty
+	cmd.Stderr = tty
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+
+	err = cmd.Start()
+	tty.Close()
+	if err != nil {
+		return -1, err
+	}
+
+	if opts.Stdin != nil {
+		go io.Copy(ptmx, opts.Stdin)
+	}
+	if opts.Resize != nil {
+		go func() {
+			for size := range opts.Resize {
+				setPtySize(ptmx, size)
+			}
+		}()
+	}
+	output := make(chan struct{})
+	go func() {
+		// Reading the pty fails with EIO once the command has exited.
+		io.Copy(opts.Stdout, ptmx)
+		close(output)
+	}()
+
+	code, err := exitCode(cmd.Wait())
+	<-output
+
+	return code, err
+}
+
+func (p *Process) wait(proc *process, stdout *os.File, stderr *os.File) {
+	err := proc.cmd.Wait()
+	stdout.Close()
+	stderr.Close()
+
+	code, err := exitCode(err)
+	if err != nil {
+		log.Printf("Error waiting for process %s: %v\n", proc.data.ID, err)
+	}
+
+	p.mu.Lock()
+	if err != nil {
+		proc.data.State.Error = err.Error()
+	}
+	proc.data.State.Status = "exited"
+	proc.data.State.Running = false
+	proc.data.State.ExitCode = int32(code)
+	proc.data.State.OOMKilled = oomKilled(proc.cgroup)
+	proc.data.State.FinishedAt = time.Now().UTC()
+	p.mu.Unlock()
+
+	// Anything the process left behind in its cgroup goes with it. Its
+	// output files stay until Stop so its logs can still be read.
+	err = killCgroup(proc.cgroup)
+	if err != nil {
+		log.Printf("Error killing cgroup %s: %v\n", proc.cgroup, err)
+	}
+	removeCgroup(proc.cgroup)
+
+	close(proc.done)
+}
+
+func (p *Process) createCgroup(c *Config, id string) (string, error) {
+	var fs syscall.Statfs_t
+	err := syscall.Statfs(filepath.Dir(p.CgroupRoot), &fs)
+	if err != nil {
+		return "", err
+	}
+	if fs.Type != cgroup2SuperMagic {
+		return "", fmt.Errorf("%s is not on a cgroup v2 filesystem", p.CgroupRoot)
+	}
+
+	err = os.MkdirAll(p.CgroupRoot, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	var controllers []string
+	if c.Cpu > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	if c.Memory > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	if len(controllers) > 0 {
+		err := os.WriteFile(filepath.Join(p.CgroupRoot, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0)
+		if err != nil {
+			return "", fmt.Errorf("error enabling %v controllers in %s: %w", controllers, p.CgroupRoot, err)
+		}
+	}
+
+	cgroup := filepath.Join(p.CgroupRoot, fmt.Sprintf("%s-%s", c.Name, id))
+	err = os.Mkdir(cgroup, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	if c.Cpu > 0 {
+		weight := cpuSharesToWeight(c.Cpu)
+		err := os.WriteFile(filepath.Join(cgroup, "cpu.weight"), []byte(strconv.FormatUint(weight, 10)), 0)
+		if err != nil {
+			removeCgroup(cgroup)
+			return "", fmt.Errorf("error setting cpu.weight: %w", err)
+		}
+	}
+	if c.Memory > 0 {
+		limit := c.Memory * 1024 * 1024
+		err := os.WriteFile(filepath.Join(cgroup, "memory.max"), []byte(strconv.FormatInt(limit, 10)), 0)
+		if err != nil {
+			removeCgroup(cgroup)
+			return "", fmt.Errorf("error setting memory.max: %w", err)
+		}
+	}
+
+	return cgroup, nil
+}
+
+// killCgroup kills every process in the cgroup and waits up to a second for
+// it to empty.
+func killCgroup(cgroup string) error {
+	err := os.WriteFile(filepath.Join(cgroup, "cgroup.kill"), []byte("1"), 0)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < 20; i++ {
+		events, err := os.ReadFile(filepath.Join(cgroup, "cgroup.events"))
+		if err != nil || strings.Contains(string(events), "populated 0") {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return nil
+}
+
+func removeCgroup(cgroup string) {
+	err := os.Remove(cgroup)
+	if err != nil {
+		log.Printf("Error removing cgroup %s: %v\n", cgroup, err)
+	}
+}
+
+// cpuSharesToWeight converts cgroup v1 CPU shares [2-262144] to a cgroup v2
+// cpu.weight [1-10000], using the same formula as runc.
+func cpuSharesToWeight(shares uint64) uint64 {
+	if shares < 2 {
+		shares = 2
+	}
+	if shares > 262144 {
+		shares = 262144
+	}
+
+	return 1 + ((shares-2)*9999)/262142
+}
+
+func oomKilled(cgroup string) bool {
+	data, err := os.ReadFile(filepath.Join(cgroup, "memory.events"))
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" && fields[1] != "0" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func envList(env map[string]string) []string {
+	var list []string
+	for k, v := range env {
+		list = append(list, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	return list
+}
+
+// writeTail writes the last n lines read from r to w, or everything when n
+// is negative.
+func writeTail(r io.Reader, n int, w io.Writer) error {
+	if n < 0 {
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	var lines []string
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" && n > 0 {
+			lines = append(lines, line)
+			if len(lines) > n {
+				lines = lines[1:]
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, line := range lines {
+		_, err := io.WriteString(w, line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// processCredential returns the user and group to run a process as, or nil to
+// run it as the worker. Only User and Group can be applied to a plain
+// process; the rest of the security context is rejected.
+func processCredential(sc SecurityContext) (*syscall.Credential, error) {
+	if len(sc.CapAdd) > 0 || len(sc.CapDrop) > 0 || sc.NoNewPrivileges || sc.ReadOnlyRootfs || sc.Privileged {
+		return nil, fmt.Errorf("the process runtime only supports User and Group in the security context")
+	}
+	if sc.User == "" && sc.Group == "" {
+		return nil, nil
+	}
+
+	credential := &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+	if sc.User != "" {
+		u, err := lookupUser(sc.User)
+		if err != nil {
+			return nil, err
+		}
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+		credential.Uid, credential.Gid = uint32(uid), uint32(gid)
+	}
+	if sc.Group != "" {
+		gid, err := strconv.ParseUint(sc.Group, 10, 32)
+		if err != nil {
+			g, err := user.LookupGroup(sc.Group)
+			if err != nil {
+				return nil, fmt.Errorf("unknown group %s: %w", sc.Group, err)
+			}
+			gid, _ = strconv.ParseUint(g.Gid, 10, 32)
+		}
+		credential.Gid = uint32(gid)
+	}
+
+	return credential, nil
+}
+
+// lookupUser finds a user by name or ID. A numeric ID with no matching user
+// is still accepted and runs with group 0.
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		u, err := user.LookupId(name)
+		if err != nil {
+			return &user.User{Uid: name, Gid: "0"}, nil
+		}
+		return u, nil
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user %s: %w", name, err)
+	}
+
+	return u, nil
+}
+
+func exitCode(err error) (int, error) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// Report a process killed by a signal the way a shell would.
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal()), nil
+		}
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	return 0, nil
+}
+
+// openPty opens a new pseudo terminal and returns its master and slave ends.
+func openPty() (*os.File, *os.File, error) {
+	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n, err := unix.IoctlGetUint32(int(ptmx.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		ptmx.Close()
+		return nil, nil, err
+	}
+	err = unix.IoctlSetPointerInt(int(ptmx.Fd()), unix.TIOCSPTLCK, 0)
+	if err != nil {
+		ptmx.Close()
+		return nil, nil, err
+	}
+
+	tty, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		ptmx.Close()
+		return nil, nil, err
+	}
+
+	return ptmx, tty, nil
+}
+
+func setPtySize(ptmx *os.File, size TerminalSize) {
+	if size.Height == 0 || size.Width == 0 {
+		return
+	}
+
+	err := unix.IoctlSetWinsize(int(ptmx.Fd()), unix.TIOCSWINSZ, &unix.Winsize{Row: size.Height, Col: size.Width})
+	if err != nil {
+		log.Printf("Error resizing pty: %v\n", err)
+	}
+}
@@ -0,0 +1,140 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Restart policies, deciding which finished tasks the manager restarts.
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+// Defaults for a restart policy's fields when they are not set.
+const (
+	DefaultRestartPolicy     = RestartOnFailure
+	DefaultRestartMaxRetries = 3
+	DefaultRestartBackoff    = 10 * time.Second
+	DefaultRestartMaxBackoff = 5 * time.Minute
+	DefaultRestartResetAfter = 10 * time.Minute
+)
+
+// RestartPolicy says whether and how quickly the manager restarts a task
+// that exits or fails its liveness probe. Durations are in seconds, with
+// zero meaning the default.
+type RestartPolicy struct {
+	// Policy is RestartNever, RestartOnFailure or RestartAlways
+	Policy string
+	// MaxRetries is how many restarts in a row are allowed, with a negative
+	// value meaning no limit
+	MaxRetries int
+	// Restarts wait InitialBackoff, doubling each time up to MaxBackoff
+	InitialBackoff uint
+	MaxBackoff     uint
+	// ResetAfter is how long a task must run for its restart count to be
+	// reset when it next exits
+	ResetAfter uint
+}
+
+// UnmarshalJSON also accepts the policy on its own as a string, which is
+// how it was given before it had settings of its own.
+func (p *RestartPolicy) UnmarshalJSON(data []byte) error {
+	var policy string
+	if err := json.Unmarshal(data, &policy); err == nil {
+		*p = RestartPolicy{Policy: policy}
+		return p.Validate()
+	}
+
+	type plain RestartPolicy
+	if err := json.Unmarshal(data, (*plain)(p)); err != nil {
+		return err
+	}
+
+	return p.Validate()
+}
+
+// Validate checks the policy is one cube knows. The names Podman uses,
+// "no" and "unless-stopped", are accepted too.
+func (p *RestartPolicy) Validate() error {
+	switch p.Policy {
+	case "", RestartNever, RestartOnFailure, RestartAlways, "no", "unless-stopped":
+		return nil
+	}
+
+	return fmt.Errorf("unknown restart policy %q", p.Policy)
+}
+
+func (p *RestartPolicy) policy() string {
+	switch p.Policy {
+	case "":
+		return DefaultRestartPolicy
+	case "no":
+		return RestartNever
+	case "unless-stopped":
+		return RestartAlways
+	}
+
+	return p.Policy
+}
+
+// ShouldRestart reports whether a task that has finished, or is running but
+// failing its liveness probe, should be restarted. Tasks that finished
+// because they were asked to stop are never restarted.
+func (p *RestartPolicy) ShouldRestart(t *Task) bool {
+	if t.Reason == ReasonStopped || t.Reason == ReasonPreStopHookFailed {
+		return false
+	}
+
+	switch p.policy() {
+	case RestartNever:
+		return false
+	case RestartOnFailure:
+		if t.State == Completed {
+			return false
+		}
+	}
+
+	max := p.MaxRetries
+	if max == 0 {
+		max = DefaultRestartMaxRetries
+	}
+	return max < 0 || t.RestartCount < max
+}
+
+// Backoff returns how long to wait before restarting a task that has
+// already been restarted the given number of times. The wait is jittered
+// so tasks that fail together are not restarted together.
+func (p *RestartPolicy) Backoff(restarts int) time.Duration {
+	initial, max := DefaultRestartBackoff, DefaultRestartMaxBackoff
+	if p.InitialBackoff != 0 {
+		initial = time.Duration(p.InitialBackoff) * time.Second
+	}
+	if p.MaxBackoff != 0 {
+		max = time.Duration(p.MaxBackoff) * time.Second
+	}
+
+	d := initial
+	for i := 0; i < restarts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	// Wait at least half the backoff, and a random part of the rest.
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// ResetWindow returns how long a task must run for its restart count to be
+// reset.
+func (p *RestartPolicy) ResetWindow() time.Duration {
+	if p.ResetAfter == 0 {
+		return DefaultRestartResetAfter
+	}
+
+	return time.Duration(p.ResetAfter) * time.Second
+}
@@ -0,0 +1,37 @@
+package task
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartPolicyBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RestartPolicy
+		restarts int
+		// want is the backoff before jitter; the result is between half of
+		// it and all of it
+		want time.Duration
+	}{
+		{"defaults, first restart", RestartPolicy{}, 0, DefaultRestartBackoff},
+		{"defaults, doubled", RestartPolicy{}, 2, 4 * DefaultRestartBackoff},
+		{"defaults, capped", RestartPolicy{}, 10, DefaultRestartMaxBackoff},
+		{"initial", RestartPolicy{InitialBackoff: 1}, 0, time.Second},
+		{"initial, doubled", RestartPolicy{InitialBackoff: 1}, 3, 8 * time.Second},
+		{"capped by max", RestartPolicy{InitialBackoff: 1, MaxBackoff: 5}, 3, 5 * time.Second},
+		{"initial above max", RestartPolicy{InitialBackoff: 60, MaxBackoff: 30}, 0, 30 * time.Second},
+		{"many restarts do not overflow", RestartPolicy{InitialBackoff: 1, MaxBackoff: 3600}, 1000, time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				got := tt.policy.Backoff(tt.restarts)
+				if got < tt.want/2 || got > tt.want {
+					t.Fatalf("Backoff(%d) = %v, want between %v and %v", tt.restarts, got, tt.want/2, tt.want)
+				}
+			}
+		})
+	}
+}
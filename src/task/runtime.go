@@ -0,0 +1,174 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	nettypes "github.com/containers/common/libnetwork/types"
+	"github.com/containers/podman/v5/libpod/define"
+	"github.com/distribution/reference"
+)
+
+// Runtime is implemented by every container engine the worker can run
+// tasks on.
+type Runtime interface {
+	Run(c *Config) ContainerResult
+	Stop(id string) ContainerResult
+	Inspect(id string) InspectResponse
+	List() ([]ContainerSummary, error)
+	Logs(ctx context.Context, id string, opts LogOptions, w io.Writer) error
+	Exec(ctx context.Context, id string, opts ExecOptions) (int, error)
+	// Wait blocks until the container exits and returns its exit code
+	Wait(ctx context.Context, id string) (int, error)
+}
+
+// PodRuntime is implemented by runtimes that can run a task group: several
+// containers sharing a network namespace and lifecycle.
+type PodRuntime interface {
+	RunPod(c *PodConfig) PodResult
+	// StopPod stops the pod's containers, keeping them for inspection
+	StopPod(id string) ContainerResult
+	// RemovePod stops the pod's containers and removes them with the pod
+	RemovePod(id string) ContainerResult
+}
+
+// PodConfig describes a pod to run. Ports are published by the pod, so the
+// members' ExposedPorts are ignored.
+type PodConfig struct {
+	Name         string
+	ExposedPorts []nettypes.PortMapping
+	Labels       map[string]string
+	Members      []*Config
+}
+
+// PodResult holds the pod's ID and its members' container IDs, in the same
+// order as PodConfig.Members.
+type PodResult struct {
+	Error        error
+	PodID        string
+	ContainerIDs []string
+}
+
+// ImageStore is implemented by runtimes that keep a local cache of images.
+// Workers use it to pre-pull images and to remove ones no longer needed.
+type ImageStore interface {
+	PullImage(image string) error
+	ListImages() ([]ImageSummary, error)
+	RemoveImage(id string) error
+}
+
+// ImageSummary describes a cached image. Names are fully qualified, as
+// returned by NormalizeImage.
+type ImageSummary struct {
+	ID      string
+	Names   []string
+	Size    int64
+	Created time.Time
+}
+
+// NormalizeImage returns the fully qualified form of an image name, e.g.
+// docker.io/library/nginx:latest for nginx, so names can be compared.
+func NormalizeImage(image string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return image
+	}
+
+	return reference.TagNameOnly(named).String()
+}
+
+// LogOptions selects which of a container's output Logs returns.
+type LogOptions struct {
+	// Follow keeps streaming output until the container exits or the
+	// context is cancelled
+	Follow bool
+	// Tail limits output to the last Tail lines; negative means all
+	Tail int
+	// Since drops output written before this time; zero means no limit
+	Since time.Time
+}
+
+// RuntimeOptions configures the runtime returned by NewRuntime.
+type RuntimeOptions struct {
+	// Endpoint is the URI of the container engine's API, e.g.
+	// unix:///run/podman/podman.sock. Empty means detect it.
+	Endpoint string
+	// Credentials used when pulling images from private registries
+	Credentials CredentialStore
+}
+
+// NewRuntime returns the Runtime registered under name ("podman", "process"
+// or "fake").
+func NewRuntime(name string, opts RuntimeOptions) (Runtime, error) {
+	switch name {
+	case "podman":
+		p, err := NewPodman(opts.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		p.Credentials = opts.Credentials
+		return p, nil
+	case "process":
+		return NewProcess(processDataDir, processCgroupRoot), nil
+	case "fake":
+		return NewFake(), nil
+	default:
+		return nil, fmt.Errorf("unknown runtime %q", name)
+	}
+}
+
+// ExecOptions describes a command run inside a running container by Exec.
+type ExecOptions struct {
+	Cmd []string
+	// Tty allocates a terminal, in which case all output goes to Stdout
+	Tty bool
+	// Stdin is nil when input is not attached
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Size is the initial terminal size and Resize delivers later changes
+	Size   TerminalSize
+	Resize <-chan TerminalSize
+}
+
+type TerminalSize struct {
+	Height uint16
+	Width  uint16
+}
+
+type ContainerResult struct {
+	Error       error
+	Action      string
+	ContainerId string
+	Result      string
+}
+
+// InspectResponse uses Podman's inspect data as the common format, so every
+// runtime reports container state the same way.
+type InspectResponse struct {
+	Error     error
+	Container *define.InspectContainerData
+}
+
+// Limits returns the CPU shares and memory limit (in MiB) the runtime
+// actually applied to the container.
+func (r InspectResponse) Limits() Limits {
+	if r.Container == nil || r.Container.HostConfig == nil {
+		return Limits{}
+	}
+
+	return Limits{
+		Cpu:    r.Container.HostConfig.CpuShares,
+		Memory: r.Container.HostConfig.Memory / 1024 / 1024,
+	}
+}
+
+type ContainerSummary struct {
+	ID     string
+	Name   string
+	Image  string
+	Status string
+	Labels map[string]string
+}
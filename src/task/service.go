@@ -0,0 +1,173 @@
+package task
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Service keeps a number of replicas of a task running. The manager
+// compares Replicas with the service's tasks and starts or stops tasks to
+// close the gap.
+type Service struct {
+	Name     string
+	Replicas int
+	Template Task
+	Strategy Strategy
+
+	// Revision counts changes to the template. Tasks are labelled with the
+	// revision they were created from, and ones from older revisions are
+	// replaced by a rollout.
+	Revision int
+	// ActiveRevision is the revision whose tasks are sent traffic under a
+	// blue/green strategy. It moves to the new revision all at once.
+	ActiveRevision int
+	CreationTime   time.Time
+	// Running and Ready count the service's tasks as of the manager's last
+	// reconcile
+	Running int
+	Ready   int
+	Message string
+	Rollout Rollout
+}
+
+// ServiceRevision records a service's template as it was at one revision,
+// so it can be rolled back to.
+type ServiceRevision struct {
+	Service   string
+	Revision  int
+	Template  Task
+	Submitter string
+	Timestamp time.Time
+	// Cause says what made the revision, such as an update or a rollback
+	Cause string
+}
+
+// Deployment strategies, deciding how a rollout replaces a service's tasks.
+const (
+	// RollingUpdate replaces tasks a few at a time
+	RollingUpdate = "RollingUpdate"
+	// Canary first runs CanaryReplicas new tasks next to the old ones, then
+	// once it is promoted carries on as a rolling update
+	Canary = "Canary"
+	// BlueGreen starts a full set of new tasks next to the old ones, then
+	// once it is promoted switches traffic to them and stops the old ones
+	BlueGreen = "BlueGreen"
+)
+
+// Strategy says how a rollout replaces a service's tasks. MaxSurge is how
+// many tasks more than Replicas may run during a rolling update, and
+// MaxUnavailable how many fewer than Replicas may be ready. When both are
+// zero, MaxSurge is one.
+type Strategy struct {
+	// Type is RollingUpdate (the default), Canary or BlueGreen
+	Type           string
+	MaxSurge       int
+	MaxUnavailable int
+	// CanaryReplicas is how many new tasks a canary runs, one if unset
+	CanaryReplicas int
+	// AutoPromote promotes a canary or blue/green rollout once its new
+	// tasks have all been ready for PromoteDelay seconds. Otherwise it
+	// waits to be promoted by hand.
+	AutoPromote  bool
+	PromoteDelay uint
+}
+
+// Rollout is the progress of replacing a service's tasks with ones from its
+// current revision.
+type Rollout struct {
+	// Paused stops the rollout where it is. The manager pauses a rollout
+	// when a task of the new revision fails.
+	Paused bool
+	// StartTime is when the rollout began or was last resumed. Only tasks
+	// failing after it pause the rollout.
+	StartTime time.Time
+	// Updated counts live tasks of the current revision, and UpdatedReady
+	// those of them that are ready
+	Updated      int
+	UpdatedReady int
+	// Outdated counts live tasks of older revisions
+	Outdated int
+	// Promoted is set once a canary or blue/green rollout has been
+	// approved, and ReadyTime is when all of its new tasks became ready
+	Promoted  bool
+	ReadyTime time.Time
+	Complete  bool
+	Message   string
+}
+
+var serviceName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// Validate checks the service has a usable name, replica count and
+// template.
+func (s *Service) Validate() error {
+	if !serviceName.MatchString(s.Name) {
+		return fmt.Errorf("service name %q must be lowercase letters, digits and dashes", s.Name)
+	}
+	if s.Replicas < 0 {
+		return fmt.Errorf("service %s has negative Replicas", s.Name)
+	}
+	if s.Template.Image == "" && len(s.Template.Members) == 0 {
+		return fmt.Errorf("service %s: template has no image", s.Name)
+	}
+	if s.Template.JobID != uuid.Nil {
+		return fmt.Errorf("service %s: template belongs to a job", s.Name)
+	}
+	if err := s.Template.Validate(); err != nil {
+		return fmt.Errorf("service %s: %w", s.Name, err)
+	}
+	switch s.Strategy.Type {
+	case "", RollingUpdate, Canary, BlueGreen:
+	default:
+		return fmt.Errorf("service %s: unknown strategy %q", s.Name, s.Strategy.Type)
+	}
+	if s.Strategy.MaxSurge < 0 || s.Strategy.MaxUnavailable < 0 || s.Strategy.CanaryReplicas < 0 {
+		return fmt.Errorf("service %s: MaxSurge, MaxUnavailable and CanaryReplicas must not be negative", s.Name)
+	}
+
+	return nil
+}
+
+// NewTask creates a replica from the service's template. Unless the
+// template gives a version, the task's version is its revision, as in "r3".
+func (s *Service) NewTask() Task {
+	t := s.Template
+	t.ID = uuid.New()
+	t.Name = fmt.Sprintf("%s-%s", s.Name, t.ID.String()[:8])
+	t.Service = s.Name
+	t.Revision = s.Revision
+	if t.Version == "" {
+		t.Version = fmt.Sprintf("r%d", s.Revision)
+	}
+	t.State = Pending
+
+	return t
+}
+
+// Limits returns the strategy's surge and unavailability, with the default
+// applied.
+func (st Strategy) Limits() (surge, unavailable int) {
+	if st.MaxSurge == 0 && st.MaxUnavailable == 0 {
+		return 1, 0
+	}
+
+	return st.MaxSurge, st.MaxUnavailable
+}
+
+// Canaries returns how many new tasks a canary rollout runs.
+func (s *Service) Canaries() int {
+	return min(max(s.Strategy.CanaryReplicas, 1), s.Replicas)
+}
+
+// Endpoint is a ready task of a service that traffic can be sent to.
+type Endpoint struct {
+	TaskID   uuid.UUID
+	Name     string
+	Version  string
+	Revision int
+	// Address is where the task's first exposed port is reachable, as
+	// host:port on its worker
+	Address string
+}
@@ -8,14 +8,22 @@ const (
 	Running
 	Completed
 	Failed
+	// Stopping tasks have been sent their stop signal and are within their
+	// grace period
+	Stopping
 )
 
 var stateTransitionMap = map[State][]State{
-	Pending:   {Scheduled},
-	Scheduled: {Scheduled, Running, Failed},
-	Running:   {Running, Completed, Failed},
-	Completed: {},
-	Failed:    {},
+	Pending: {Scheduled},
+	// Scheduled tasks may be stopped before the worker starts them
+	Scheduled: {Scheduled, Running, Completed, Failed},
+	// Running tasks go back to Scheduled when they are restarted for
+	// failing their liveness probe, and finished tasks when they are
+	// restarted under their restart policy
+	Running:   {Running, Stopping, Completed, Failed, Scheduled},
+	Stopping:  {Stopping, Completed, Failed},
+	Completed: {Scheduled},
+	Failed:    {Scheduled},
 }
 
 func Contains(states []State, state State) bool {
@@ -44,6 +52,8 @@ func (s State) String() string {
 		return "Completed"
 	case Failed:
 		return "Failed"
+	case Stopping:
+		return "Stopping"
 	default:
 		return "Unknown"
 	}
@@ -1,39 +1,205 @@
 package task
 
 import (
-	"log"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"context"
-
 	nettypes "github.com/containers/common/libnetwork/types"
 	"github.com/containers/podman/v5/libpod/define"
-	"github.com/containers/podman/v5/pkg/bindings"
-	"github.com/containers/podman/v5/pkg/bindings/containers"
-	"github.com/containers/podman/v5/pkg/bindings/images"
-	"github.com/containers/podman/v5/pkg/specgen"
+	"github.com/distribution/reference"
 	"github.com/google/uuid"
-	// "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
 )
 
 type Task struct {
-	ID            uuid.UUID
-	ContainerID   string
-	Name          string
-	State         State
-	Image         string
-	Cpu           uint64
-	Memory        int64
-	Disk          int64
-	ExposedPorts  []nettypes.PortMapping
-	HostPorts     map[string][]define.InspectHostPort
-	PortBindings  map[string]string
-	RestartPolicy string
-	StartTime     time.Time
-	FinishTime    time.Time
-	HealthCheck   string
-	RestartCount  int
+	ID          uuid.UUID
+	ContainerID string
+	Name        string
+	State       State
+	Image       string
+	// ImagePullPolicy is one of PullAlways, PullIfNotPresent or PullNever;
+	// empty picks a default from the image's tag
+	ImagePullPolicy string
+	Env             map[string]string
+	Command         []string
+	Args            []string
+	WorkingDir      string
+	Mounts          []Mount
+	Cpu             uint64
+	Memory          int64
+	Disk            int64
+	ExposedPorts    []nettypes.PortMapping
+	HostPorts       map[string][]define.InspectHostPort
+	PortBindings    map[string]string
+	RestartPolicy   RestartPolicy
+	// StopSignal is sent to stop the task, e.g. "SIGTERM" (the default) or
+	// "SIGQUIT"; StopTimeout is the grace period in seconds before the task
+	// is killed, with zero meaning DefaultStopTimeout
+	StopSignal      string
+	StopTimeout     uint
+	SecurityContext SecurityContext
+	StartTime       time.Time
+	FinishTime      time.Time
+	// HealthCheck is a path to GET on the first exposed port; prefer
+	// LivenessProbe, which replaces it when set
+	HealthCheck string
+	// LivenessProbe failing means the task is broken and should be
+	// restarted; ReadinessProbe failing means it should not be sent traffic.
+	// The worker runs both and reports their status in Liveness and
+	// Readiness.
+	LivenessProbe  *Probe
+	ReadinessProbe *Probe
+	Liveness       ProbeStatus
+	Readiness      ProbeStatus
+	RestartCount   int
+	// NextRestart is when the manager will restart the task, if it is
+	// waiting out a backoff
+	NextRestart   time.Time
+	AppliedLimits Limits
+	// ExitCode, OOMKilled and TerminationMessage describe how the task's
+	// container ended. They are only meaningful once the task has finished.
+	ExitCode           int
+	OOMKilled          bool
+	TerminationMessage string
+	// Reason is set when a task failed for a more specific cause than its
+	// container's exit, such as a failed hook
+	Reason string
+	// InitContainers run one after another, each to completion, before the
+	// task's container starts
+	InitContainers []Task
+	// PostStart runs in the container once it has started, and PreStop just
+	// before it is sent its stop signal. PreStop may run for up to the grace
+	// period, which then starts over for the stop signal.
+	PostStart []string
+	PreStop   []string
+	// JobID is set on tasks created by a batch job. They run to completion
+	// and are not health-checked.
+	JobID uuid.UUID
+	// Service is set on tasks run as replicas of a service, which replaces
+	// them if they stop for good. Revision is the service revision the
+	// task was created from.
+	Service  string
+	Revision int
+	// Version labels what the task runs, such as "v2", so tasks of
+	// different versions can be told apart
+	Version string
+	// Members makes the task a group: the members run together as one pod,
+	// sharing a network namespace and lifecycle, and the task's own
+	// container fields are unused apart from Name and ExposedPorts
+	Members []Member
+}
+
+// Reasons for a task failing, stored in Task.Reason.
+const (
+	ReasonInitContainerFailed = "InitContainerFailed"
+	ReasonPostStartHookFailed = "PostStartHookFailed"
+	ReasonPreStopHookFailed   = "PreStopHookFailed"
+	// ReasonStopped marks a task that finished because it was asked to stop
+	ReasonStopped = "Stopped"
+)
+
+// Member is one container of a task group.
+type Member struct {
+	Task
+	// Optional members, such as a log shipper, may exit or fail without
+	// failing the group
+	Optional bool
+}
+
+// Resources is what a task needs from the node it is scheduled on.
+type Resources struct {
+	Cpu    uint64
+	Memory int64
+	Disk   int64
+}
+
+// Resources returns what the task needs, summed over its members for a
+// task group.
+func (t *Task) Resources() Resources {
+	r := Resources{Cpu: t.Cpu, Memory: t.Memory, Disk: t.Disk}
+	for _, m := range t.Members {
+		mr := m.Resources()
+		r.Cpu += mr.Cpu
+		r.Memory += mr.Memory
+		r.Disk += mr.Disk
+	}
+
+	return r
+}
+
+// Validate checks what the worker would otherwise only reject when it runs
+// the task: the image pull policies of the task, its init containers and its
+// members.
+func (t *Task) Validate() error {
+	switch t.ImagePullPolicy {
+	case "", PullAlways, PullIfNotPresent, PullNever:
+	default:
+		return fmt.Errorf("task %s: unknown image pull policy %q", t.Name, t.ImagePullPolicy)
+	}
+	for i := range t.InitContainers {
+		if err := t.InitContainers[i].Validate(); err != nil {
+			return err
+		}
+	}
+	for i := range t.Members {
+		if err := t.Members[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const (
+	MountVolume = "volume"
+	MountBind   = "bind"
+	MountTmpfs  = "tmpfs"
+)
+
+// Mount attaches storage to a task's container.
+type Mount struct {
+	// Type is one of "volume", "bind" or "tmpfs"
+	Type string
+	// Source is the volume name for a volume mount, or the host path for a
+	// bind mount. A volume mount without a Source gets an anonymous volume
+	// that is removed together with the container.
+	Source string
+	// Target is the absolute path of the mount inside the container
+	Target   string
+	ReadOnly bool
+}
+
+// Limits holds the resources a runtime enforces on a task, in the same units
+// as Config: CPU in shares and memory in MiB.
+type Limits struct {
+	Cpu    uint64
+	Memory int64
+}
+
+// SecurityContext restricts what a task's container may do. The zero value
+// keeps the runtime's defaults.
+type SecurityContext struct {
+	// User and Group to run as, by name or numeric ID
+	User  string
+	Group string
+	// CapAdd and CapDrop adjust the default capability set, e.g. "NET_ADMIN"
+	// or "ALL"
+	CapAdd  []string
+	CapDrop []string
+	// NoNewPrivileges stops processes gaining privileges through setuid
+	// binaries and the like
+	NoNewPrivileges bool
+	// ReadOnlyRootfs mounts the container's root filesystem read-only
+	ReadOnlyRootfs bool
+	// Privileged gives the container full access to the host; workers
+	// refuse it unless they allow privileged tasks
+	Privileged bool
 }
 
 type TaskEvent struct {
@@ -55,10 +221,18 @@ type Config struct {
 	AttachStderr bool
 	// ExposedPorts list of ports exposed
 	ExposedPorts []nettypes.PortMapping
+	// Entrypoint overrides the image's entrypoint (optional)
+	Entrypoint []string
 	// Cmd to be run inside container (optional)
 	Cmd []string
+	// WorkingDir overrides the image's working directory (optional)
+	WorkingDir string
+	// Mounts to attach to the container
+	Mounts []Mount
 	// Image used to run the container
 	Image string
+	// ImagePullPolicy decides when Image is pulled (see PullPolicy)
+	ImagePullPolicy string
 	// Cpu in shares (request)
 	Cpu uint64
 	// Memory in MiB
@@ -67,106 +241,138 @@ type Config struct {
 	Disk int64
 	// Env variables
 	Env map[string]string
-	// RestartPolicy for the container ["", "always", "unless-stopped", "on-failure"]
-	RestartPolicy string
+	// StopSignal and StopTimeout (in seconds) control how the container is
+	// stopped (see Signal and GracePeriod)
+	StopSignal  string
+	StopTimeout uint
+	// SecurityContext for the container
+	SecurityContext SecurityContext
+	// Labels attached to the container
+	Labels map[string]string
 }
 
-func NewConfig(t *Task) *Config {
-	return &Config{
-		Name:          t.Name,
-		ExposedPorts:  t.ExposedPorts,
-		Image:         t.Image,
-		Cpu:           t.Cpu,
-		Memory:        t.Memory,
-		Disk:          t.Disk,
-		RestartPolicy: t.RestartPolicy,
-	}
-}
-
-type Podman struct {
-	Conn   context.Context
-	Config Config
-}
+// Defaults for stopping a container when the task does not set them.
+const (
+	DefaultStopSignal  = "SIGTERM"
+	DefaultStopTimeout = 10 * time.Second
+)
 
-type PodmanInspectResponse struct {
-	Error     error
-	Container *define.InspectContainerData
-}
+// Signal returns the signal that stops the container. The stop signal may be
+// given by name, with or without the SIG prefix, or by number.
+func (c *Config) Signal() (syscall.Signal, error) {
+	name := c.StopSignal
+	if name == "" {
+		name = DefaultStopSignal
+	}
 
-func NewPodman(c *Config) (*Podman, error) {
-	conn, err := bindings.NewConnection(context.Background(), "unix:///run/user/1000/podman/podman.sock")
-	if err != nil {
-		log.Printf("Error creating Podman connection: %s\n", err)
-		return nil, err
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return 0, fmt.Errorf("unknown stop signal %q", c.StopSignal)
 	}
 
-	return &Podman{Conn: conn, Config: *c}, nil
+	return sig, nil
 }
 
-func (p *Podman) Inspect(containerID string) PodmanInspectResponse {
-
-	resp, err := containers.Inspect(p.Conn, p.Config.Name, nil)
-	if err != nil {
-		log.Printf("Error inspecting container: %s\n", err)
-		return PodmanInspectResponse{Error: err}
+// GracePeriod returns how long the container has to exit after the stop
+// signal before it is killed.
+func (c *Config) GracePeriod() time.Duration {
+	if c.StopTimeout == 0 {
+		return DefaultStopTimeout
 	}
 
-	return PodmanInspectResponse{Container: resp}
+	return time.Duration(c.StopTimeout) * time.Second
 }
 
-type ContainerResult struct {
-	Error       error
-	Action      string
-	ContainerId string
-	Result      string
-}
+// Image pull policies.
+const (
+	PullAlways       = "Always"
+	PullIfNotPresent = "IfNotPresent"
+	PullNever        = "Never"
+)
 
-func (p *Podman) Run() ContainerResult {
-	irp, err := images.Pull(p.Conn, p.Config.Image, nil)
-	if err != nil {
-		log.Printf("Error pulling image %s: %v\n", p.Config.Image, err)
-		return ContainerResult{Error: err}
+// PullPolicy returns the config's image pull policy. Without one, images
+// tagged latest or not tagged at all are always pulled, since the tag may
+// have moved, and anything else is only pulled when it is not present.
+func (c *Config) PullPolicy() string {
+	if c.ImagePullPolicy != "" {
+		return c.ImagePullPolicy
 	}
-	log.Printf("%s", strings.Join(irp, "\n"))
-
-	// mib := p.Config.Memory * 1024 * 1024
-
-	s := specgen.NewSpecGenerator(p.Config.Image, false)
-	s.RestartPolicy = p.Config.RestartPolicy
-	// s.ResourceLimits = &specs.LinuxResources{Memory: &specs.LinuxMemory{Limit: &mib}, CPU: &specs.LinuxCPU{Shares: &p.Config.Cpu}}
-	s.Name = p.Config.Name
-	s.Env = p.Config.Env
-	s.PortMappings = p.Config.ExposedPorts
 
-	createResponse, err := containers.CreateWithSpec(p.Conn, s, nil)
+	named, err := reference.ParseNormalizedNamed(c.Image)
 	if err != nil {
-		log.Printf("Error creating container %s: %v", p.Config.Name, err)
-		return ContainerResult{Error: err}
+		return PullAlways
 	}
-	log.Printf("Container %s:%s created", p.Config.Name, createResponse.ID)
-
-	if err := containers.Start(p.Conn, createResponse.ID, nil); err != nil {
-		log.Printf("Error starting container %s:%s -> %v", p.Config.Name, createResponse.ID, err)
-		return ContainerResult{Error: err}
+	if _, ok := named.(reference.Digested); ok {
+		return PullIfNotPresent
+	}
+	if tagged, ok := named.(reference.Tagged); ok && tagged.Tag() != "latest" {
+		return PullIfNotPresent
 	}
-	log.Printf("Container %s:%s started", p.Config.Name, createResponse.ID)
 
-	return ContainerResult{ContainerId: createResponse.ID, Action: "start", Result: "success"}
+	return PullAlways
 }
 
-func (p *Podman) Stop(id string) ContainerResult {
-	log.Printf("Attempting to stop container %v", id)
-	err := containers.Stop(p.Conn, id, nil)
-	if err != nil {
-		log.Printf("Error stopping container %s: %v\n", id, err)
-		return ContainerResult{Error: err}
+// Labels the worker puts on every container it creates, so a container can be
+// traced back to its task.
+const (
+	LabelTaskID   = "cube.task.id"
+	LabelWorker   = "cube.worker"
+	LabelSpecHash = "cube.spec-hash"
+	// LabelInitFor is put on init containers instead of LabelTaskID and
+	// LabelSpecHash, so they cannot be taken for their task's container
+	LabelInitFor = "cube.init-for"
+)
+
+func NewConfig(t *Task) *Config {
+	return &Config{
+		Name:            t.Name,
+		ExposedPorts:    t.ExposedPorts,
+		Image:           t.Image,
+		ImagePullPolicy: t.ImagePullPolicy,
+		Env:             t.Env,
+		Entrypoint:      t.Command,
+		Cmd:             t.Args,
+		WorkingDir:      t.WorkingDir,
+		Mounts:          t.Mounts,
+		Cpu:             t.Cpu,
+		Memory:          t.Memory,
+		Disk:            t.Disk,
+		StopSignal:      t.StopSignal,
+		StopTimeout:     t.StopTimeout,
+		SecurityContext: t.SecurityContext,
 	}
+}
 
-	_, err = containers.Remove(p.Conn, id, nil)
-	if err != nil {
-		log.Printf("Error removing container %s: %v\n", id, err)
-		return ContainerResult{Error: err}
+// SpecHash returns a short hash of the fields that decide what a task's
+// container runs. Two tasks with the same hash produce identical containers.
+func SpecHash(t *Task) string {
+	spec := struct {
+		Name            string
+		Image           string
+		ImagePullPolicy string
+		Env             map[string]string
+		Command         []string
+		Args            []string
+		WorkingDir      string
+		Mounts          []Mount
+		Cpu             uint64
+		Memory          int64
+		Disk            int64
+		ExposedPorts    []nettypes.PortMapping
+		SecurityContext SecurityContext
+	}{
+		t.Name, t.Image, t.ImagePullPolicy, t.Env, t.Command, t.Args, t.WorkingDir, t.Mounts,
+		t.Cpu, t.Memory, t.Disk, t.ExposedPorts, t.SecurityContext,
 	}
 
-	return ContainerResult{Action: "stop", Result: "success", Error: nil}
+	data, _ := json.Marshal(spec)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
 }
@@ -0,0 +1,261 @@
+package task
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type WorkflowState int
+
+const (
+	WorkflowPending WorkflowState = iota
+	WorkflowRunning
+	WorkflowSucceeded
+	WorkflowFailed
+)
+
+func (s WorkflowState) String() string {
+	switch s {
+	case WorkflowPending:
+		return "Pending"
+	case WorkflowRunning:
+		return "Running"
+	case WorkflowSucceeded:
+		return "Succeeded"
+	case WorkflowFailed:
+		return "Failed"
+	}
+
+	return fmt.Sprintf("WorkflowState(%d)", int(s))
+}
+
+type StepState int
+
+const (
+	// StepWaiting steps are waiting for the steps they depend on
+	StepWaiting StepState = iota
+	StepRunning
+	StepSucceeded
+	StepFailed
+	// StepSkipped steps never ran because a step they depend on failed or
+	// the workflow failed fast
+	StepSkipped
+)
+
+func (s StepState) String() string {
+	switch s {
+	case StepWaiting:
+		return "Waiting"
+	case StepRunning:
+		return "Running"
+	case StepSucceeded:
+		return "Succeeded"
+	case StepFailed:
+		return "Failed"
+	case StepSkipped:
+		return "Skipped"
+	}
+
+	return fmt.Sprintf("StepState(%d)", int(s))
+}
+
+// Failure policies, deciding what a workflow does when a step fails.
+const (
+	// FailFast stops every running step and skips the rest
+	FailFast = "FailFast"
+	// Continue runs every step that does not depend on the failed one
+	Continue = "Continue"
+)
+
+// OutputPrefix starts a line of a step's output that passes a value to the
+// steps that depend on it, as in "CUBE_OUTPUT rows=1024".
+const OutputPrefix = "CUBE_OUTPUT "
+
+// MaxOutputSize limits the total size of a step's output values.
+const MaxOutputSize = 4096
+
+// Workflow runs a set of steps, each a task, ordered by their dependencies.
+// A step starts once every step it depends on has succeeded.
+type Workflow struct {
+	ID   uuid.UUID
+	Name string
+	// FailurePolicy is FailFast (the default) or Continue
+	FailurePolicy string
+	Steps         []Step
+
+	// The rest is the workflow's status, kept by the manager.
+	State          WorkflowState
+	StartTime      time.Time
+	CompletionTime time.Time
+	Message        string
+}
+
+// Step is one node of a workflow. Outputs of the steps it depends on are
+// passed to its task as environment variables named for the step and key,
+// such as EXTRACT_ROWS for the output rows of step extract.
+type Step struct {
+	Name      string
+	DependsOn []string
+	Task      Task
+	// Retries is how many times the step is run again if it fails
+	Retries int
+
+	State    StepState
+	TaskID   uuid.UUID
+	Attempts int
+	Outputs  map[string]string
+	Message  string
+}
+
+var outputKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Validate checks the workflow's steps have unique names, depend only on
+// steps that exist and do not depend on each other in a cycle.
+func (wf *Workflow) Validate() error {
+	if wf.Name == "" {
+		return fmt.Errorf("workflow has no name")
+	}
+	switch wf.FailurePolicy {
+	case "", FailFast, Continue:
+	default:
+		return fmt.Errorf("workflow %s: unknown failure policy %q", wf.Name, wf.FailurePolicy)
+	}
+	if len(wf.Steps) == 0 {
+		return fmt.Errorf("workflow %s has no steps", wf.Name)
+	}
+
+	steps := make(map[string]*Step)
+	for i := range wf.Steps {
+		s := &wf.Steps[i]
+		if !outputKey.MatchString(s.Name) {
+			return fmt.Errorf("workflow %s: step name %q must be letters, digits and underscores", wf.Name, s.Name)
+		}
+		if _, ok := steps[s.Name]; ok {
+			return fmt.Errorf("workflow %s: more than one step is named %s", wf.Name, s.Name)
+		}
+		if s.Retries < 0 {
+			return fmt.Errorf("workflow %s: step %s has negative Retries", wf.Name, s.Name)
+		}
+		if err := s.Task.Validate(); err != nil {
+			return fmt.Errorf("workflow %s: step %s: %w", wf.Name, s.Name, err)
+		}
+		steps[s.Name] = s
+	}
+	for _, s := range wf.Steps {
+		for _, d := range s.DependsOn {
+			if _, ok := steps[d]; !ok {
+				return fmt.Errorf("workflow %s: step %s depends on unknown step %s", wf.Name, s.Name, d)
+			}
+		}
+	}
+
+	// Depth-first search, looking for a step reached again while it is
+	// still being visited.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	mark := make(map[string]int)
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch mark[name] {
+		case visiting:
+			return fmt.Errorf("workflow %s: steps depend on each other in a cycle: %s", wf.Name, strings.Join(append(path, name), " -> "))
+		case visited:
+			return nil
+		}
+		mark[name] = visiting
+		for _, d := range steps[name].DependsOn {
+			if err := visit(d, append(path, name)); err != nil {
+				return err
+			}
+		}
+		mark[name] = visited
+		return nil
+	}
+	for _, s := range wf.Steps {
+		if err := visit(s.Name, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Step returns the step with the given name, or nil.
+func (wf *Workflow) Step(name string) *Step {
+	for i := range wf.Steps {
+		if wf.Steps[i].Name == name {
+			return &wf.Steps[i]
+		}
+	}
+
+	return nil
+}
+
+// Finished reports whether the workflow has succeeded or failed.
+func (wf *Workflow) Finished() bool {
+	return wf.State == WorkflowSucceeded || wf.State == WorkflowFailed
+}
+
+// Finished reports whether the step has succeeded, failed or been skipped.
+func (s *Step) Finished() bool {
+	return s.State == StepSucceeded || s.State == StepFailed || s.State == StepSkipped
+}
+
+// NewTask creates the task for the step's next attempt, with the outputs of
+// the steps it depends on in its environment. As with jobs, task names carry
+// part of the workflow's ID so a resubmitted workflow gets new names.
+func (wf *Workflow) NewTask(s *Step) Task {
+	t := s.Task
+	t.ID = uuid.New()
+	t.Name = fmt.Sprintf("%s-%s-%s-%d", wf.Name, wf.ID.String()[:8], s.Name, s.Attempts)
+	t.State = Pending
+	// A workflow retries its failed steps rather than restarting them.
+	t.RestartPolicy = RestartPolicy{Policy: RestartNever}
+
+	env := make(map[string]string)
+	for k, v := range s.Task.Env {
+		env[k] = v
+	}
+	for _, d := range s.DependsOn {
+		for k, v := range wf.Step(d).Outputs {
+			env[strings.ToUpper(d+"_"+k)] = v
+		}
+	}
+	t.Env = env
+
+	return t
+}
+
+// ParseOutputs collects the output values from lines of a step's logs. A
+// later value for a key replaces an earlier one, and values past
+// MaxOutputSize in total are dropped.
+func ParseOutputs(lines []string) (map[string]string, error) {
+	outputs := make(map[string]string)
+	size := 0
+	var err error
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r\n")
+		if !strings.HasPrefix(line, OutputPrefix) {
+			continue
+		}
+		k, v, ok := strings.Cut(strings.TrimPrefix(line, OutputPrefix), "=")
+		if !ok || !outputKey.MatchString(k) {
+			continue
+		}
+		if size+len(k)+len(v) > MaxOutputSize {
+			err = fmt.Errorf("outputs are larger than %d bytes, dropped %s", MaxOutputSize, k)
+			continue
+		}
+		size += len(k) + len(v)
+		outputs[k] = v
+	}
+
+	return outputs, err
+}
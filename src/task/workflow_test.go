@@ -0,0 +1,91 @@
+package task
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWorkflowValidate(t *testing.T) {
+	step := func(name string, deps ...string) Step {
+		return Step{Name: name, DependsOn: deps, Task: Task{Image: "alpine"}}
+	}
+
+	tests := []struct {
+		name  string
+		steps []Step
+		// wantErr is part of the expected error, or empty for none
+		wantErr string
+	}{
+		{
+			name:  "single step",
+			steps: []Step{step("a")},
+		},
+		{
+			name:  "diamond",
+			steps: []Step{step("a"), step("b", "a"), step("c", "a"), step("d", "b", "c")},
+		},
+		{
+			name:  "steps listed before their dependencies",
+			steps: []Step{step("c", "b"), step("b", "a"), step("a")},
+		},
+		{
+			name:    "no steps",
+			wantErr: "has no steps",
+		},
+		{
+			name:    "depends on itself",
+			steps:   []Step{step("a", "a")},
+			wantErr: "cycle: a -> a",
+		},
+		{
+			name:    "two step cycle",
+			steps:   []Step{step("a", "b"), step("b", "a")},
+			wantErr: "cycle: a -> b -> a",
+		},
+		{
+			name:    "cycle below a valid step",
+			steps:   []Step{step("a"), step("b", "a", "d"), step("c", "b"), step("d", "c")},
+			wantErr: "cycle: b -> d -> c -> b",
+		},
+		{
+			name:    "unknown dependency",
+			steps:   []Step{step("a", "z")},
+			wantErr: "depends on unknown step z",
+		},
+		{
+			name:    "duplicate names",
+			steps:   []Step{step("a"), step("a")},
+			wantErr: "more than one step is named a",
+		},
+		{
+			name:    "bad step name",
+			steps:   []Step{step("load-data")},
+			wantErr: "must be letters, digits and underscores",
+		},
+		{
+			name:    "negative retries",
+			steps:   []Step{{Name: "a", Retries: -1}},
+			wantErr: "negative Retries",
+		},
+		{
+			name:    "unknown pull policy",
+			steps:   []Step{{Name: "a", Task: Task{Image: "alpine", ImagePullPolicy: "Sometimes"}}},
+			wantErr: "unknown image pull policy",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf := Workflow{Name: "wf", Steps: tt.steps}
+			err := wf.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Validate() = %v, want no error", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("Validate() = nil, want error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
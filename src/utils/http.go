@@ -0,0 +1,19 @@
+package utils
+
+import "net/http"
+
+// FlushWriter flushes the response after every write, so streamed output
+// reaches the client as soon as it is produced.
+type FlushWriter struct {
+	W http.ResponseWriter
+}
+
+func (f FlushWriter) Write(p []byte) (int, error) {
+	n, err := f.W.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	err = http.NewResponseController(f.W).Flush()
+	return n, err
+}
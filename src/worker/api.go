@@ -26,9 +26,15 @@ func (a *Api) initRouter() {
 		r.Get("/", a.GetTasksHandler)
 		r.Route("/{taskID}", func(r chi.Router) {
 			r.Delete("/", a.StopTaskHandler)
+			r.Get("/logs", a.GetTaskLogsHandler)
+			r.Post("/exec", a.ExecTaskHandler)
 		})
 		a.Router.Route("/stats", func(r chi.Router) {
 			r.Get("/", a.GetStatsHandler)
 		})
 	})
+	a.Router.Route("/images", func(r chi.Router) {
+		r.Post("/", a.PullImagesHandler)
+		r.Get("/", a.GetImagesHandler)
+	})
 }
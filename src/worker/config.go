@@ -0,0 +1,45 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Config holds the worker settings that can be kept in a JSON config file.
+// Flags passed on the command line override values read from the file.
+type Config struct {
+	// Runtime used to run tasks ("podman", "process" or "fake")
+	Runtime string
+	// RuntimeEndpoint is the URI of the runtime's API socket
+	RuntimeEndpoint string
+	// OrphanPolicy decides what happens to this worker's containers that
+	// belong to no known task ("remove" or "keep")
+	OrphanPolicy string
+	// RegistryCredentials is a JSON file of registry logins used when
+	// pulling images (see task.LoadCredentials)
+	RegistryCredentials string
+	// ImageTTL is how long an image may go unused before it can be removed,
+	// as a duration such as "24h"
+	ImageTTL string
+	// ImageGCThreshold is the disk usage percentage above which unused
+	// images are removed; zero disables image garbage collection
+	ImageGCThreshold float64
+	// AllowPrivileged lets tasks run with a privileged security context
+	AllowPrivileged bool
+}
+
+func LoadConfig(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file %s: %w", filename, err)
+	}
+
+	var c Config
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse config file %s: %w", filename, err)
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"context"
+	"cube/stream"
+	"cube/task"
+	"fmt"
+	"io"
+	"log"
+)
+
+// ExecRequest is the body of a request to run a command in a task. Once it
+// is accepted the connection is upgraded to the stream protocol.
+type ExecRequest struct {
+	Cmd []string
+	Tty bool
+	// Stdin attaches the client's input to the command
+	Stdin  bool
+	Height uint16
+	Width  uint16
+}
+
+// ExecTask runs a command in the task's container, reading stdin and resize
+// frames from r and writing output frames and finally the exit code to wr.
+func (w *Worker) ExecTask(t task.Task, req ExecRequest, r io.Reader, wr io.Writer) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mux := stream.NewMux(wr)
+	resize := make(chan task.TerminalSize, 1)
+
+	var stdinR *io.PipeReader
+	var stdinW *io.PipeWriter
+	if req.Stdin {
+		stdinR, stdinW = io.Pipe()
+		defer stdinR.Close()
+	}
+
+	go func() {
+		defer close(resize)
+		for {
+			s, payload, err := stream.ReadFrame(r)
+			if err != nil {
+				// The client went away, so stop the command.
+				if stdinW != nil {
+					stdinW.Close()
+				}
+				cancel()
+				return
+			}
+
+			switch s {
+			case stream.Stdin:
+				if stdinW == nil {
+					continue
+				}
+				if len(payload) == 0 {
+					stdinW.Close()
+					continue
+				}
+				stdinW.Write(payload)
+			case stream.Resize:
+				height, width := stream.Size(payload)
+				select {
+				case resize <- task.TerminalSize{Height: height, Width: width}:
+				default:
+				}
+			}
+		}
+	}()
+
+	opts := task.ExecOptions{
+		Cmd:    req.Cmd,
+		Tty:    req.Tty,
+		Stdout: mux.Writer(stream.Stdout),
+		Stderr: mux.Writer(stream.Stderr),
+		Size:   task.TerminalSize{Height: req.Height, Width: req.Width},
+		Resize: resize,
+	}
+	if stdinR != nil {
+		opts.Stdin = stdinR
+	}
+
+	log.Printf("Running %v in task %v\n", req.Cmd, t.ID)
+	code, err := w.Runtime.Exec(ctx, t.ContainerID, opts)
+	if err != nil {
+		log.Printf("Error running %v in task %v: %v\n", req.Cmd, t.ID, err)
+		fmt.Fprintf(mux.Writer(stream.Stderr), "error: %v\n", err)
+	}
+
+	err = mux.WriteExit(code)
+	if err != nil {
+		log.Printf("Error sending exit code for task %v: %v\n", t.ID, err)
+	}
+}
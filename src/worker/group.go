@@ -0,0 +1,208 @@
+package worker
+
+import (
+	"cube/task"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// startGroup runs a task group's members together as one pod.
+func (w *Worker) startGroup(t task.Task) task.ContainerResult {
+	pr, ok := w.Runtime.(task.PodRuntime)
+	if !ok {
+		return w.failTask(&t, errors.New("runtime does not support task groups"))
+	}
+
+	if len(t.InitContainers) > 0 || len(t.PostStart) > 0 || len(t.PreStop) > 0 || hasProbes(&t) {
+		return w.failTask(&t, errors.New("task groups do not support init containers, lifecycle hooks or probes"))
+	}
+
+	c := &task.PodConfig{
+		Name:         t.Name,
+		ExposedPorts: t.ExposedPorts,
+		Labels:       w.labels(&t),
+	}
+	for i := range t.Members {
+		m := &t.Members[i]
+		if m.ID == uuid.Nil {
+			m.ID = uuid.New()
+		}
+		if len(m.Members) > 0 {
+			return w.failTask(&t, fmt.Errorf("member %s of a task group cannot be a group itself", m.Name))
+		}
+		if len(m.InitContainers) > 0 || len(m.PostStart) > 0 || len(m.PreStop) > 0 || hasProbes(&m.Task) {
+			return w.failTask(&t, fmt.Errorf("member %s: task groups do not support init containers, lifecycle hooks or probes", m.Name))
+		}
+		if m.SecurityContext.Privileged && !w.AllowPrivileged {
+			return w.failTask(&t, fmt.Errorf("privileged tasks are not allowed on worker %s", w.Name))
+		}
+
+		mc := task.NewConfig(&m.Task)
+		mc.Name = t.Name + "-" + m.Name
+		mc.Labels = w.labels(&m.Task)
+		// The pod owns the network namespace, so it publishes every port.
+		c.ExposedPorts = append(c.ExposedPorts, m.ExposedPorts...)
+		c.Members = append(c.Members, mc)
+		w.useImage(m.Image)
+	}
+
+	result := pr.RunPod(c)
+	if result.Error != nil {
+		return w.failTask(&t, result.Error)
+	}
+
+	t.ContainerID = result.PodID
+	t.State = task.Running
+	for i := range t.Members {
+		t.Members[i].ContainerID = result.ContainerIDs[i]
+		t.Members[i].State = task.Running
+		t.Members[i].StartTime = t.StartTime
+	}
+	err := w.Db.Put(t.ID.String(), &t)
+	if err != nil {
+		fmt.Printf("Error updating task: %v", err)
+	}
+
+	return task.ContainerResult{ContainerId: result.PodID, Action: "start", Result: "success"}
+}
+
+// updateGroup refreshes a running group's members. The group fails as soon
+// as a required member fails, and completes once every required member has
+// exited cleanly. Either way the rest of its pod is stopped. A group whose
+// members are all optional runs until all of them have finished.
+func (w *Worker) updateGroup(t *task.Task) {
+	allOptional := true
+	for _, m := range t.Members {
+		allOptional = allOptional && m.Optional
+	}
+
+	running := false
+	var failed *task.Member
+	for i := range t.Members {
+		m := &t.Members[i]
+		if m.State == task.Running {
+			w.refreshTask(&m.Task)
+		}
+		if m.Optional && !allOptional {
+			continue
+		}
+		switch m.State {
+		case task.Running:
+			running = true
+		case task.Failed:
+			if failed == nil {
+				failed = m
+			}
+		}
+	}
+
+	// Ports are published by the pod, so every member reports the same ones.
+	for _, m := range t.Members {
+		if len(m.HostPorts) > 0 {
+			t.HostPorts = m.HostPorts
+			break
+		}
+	}
+
+	switch {
+	case failed != nil:
+		t.State = task.Failed
+		t.ExitCode = failed.ExitCode
+		t.OOMKilled = failed.OOMKilled
+		t.TerminationMessage = fmt.Sprintf("member %s failed: %s", failed.Name, failed.TerminationMessage)
+	case !running:
+		t.State = task.Completed
+		t.TerminationMessage = ""
+	default:
+		return
+	}
+	t.FinishTime = time.Now().UTC()
+
+	log.Printf("Task group %v is %v, stopping its pod %v\n", t.ID, t.State, t.ContainerID)
+	if pr, ok := w.Runtime.(task.PodRuntime); ok {
+		result := pr.StopPod(t.ContainerID)
+		if result.Error != nil {
+			log.Printf("Error stopping pod %v: %v\n", t.ContainerID, result.Error)
+		}
+	}
+	markMembersStopped(t, "stopped with the task group")
+}
+
+// stopGroup removes a task group's pod along with its members' containers.
+func (w *Worker) stopGroup(t *task.Task) task.ContainerResult {
+	pr, ok := w.Runtime.(task.PodRuntime)
+	if !ok {
+		return task.ContainerResult{Error: errors.New("runtime does not support task groups")}
+	}
+
+	result := pr.RemovePod(t.ContainerID)
+	markMembersStopped(t, "stopped")
+
+	return result
+}
+
+func markMembersStopped(t *task.Task, msg string) {
+	for i := range t.Members {
+		m := &t.Members[i]
+		if m.State == task.Running {
+			m.State = task.Completed
+			m.FinishTime = time.Now().UTC()
+			m.TerminationMessage = msg
+		}
+	}
+}
+
+// GetTask returns the task with the given ID. Members of task groups are
+// found too, although they are stored as part of their group.
+func (w *Worker) GetTask(id string) (*task.Task, error) {
+	result, err := w.Db.Get(id)
+	if err == nil {
+		return result.(*task.Task), nil
+	}
+
+	m := w.findMember(id)
+	if m == nil {
+		return nil, err
+	}
+
+	return &m.Task, nil
+}
+
+func (w *Worker) findMember(id string) *task.Member {
+	tasks, err := w.Db.List()
+	if err != nil {
+		return nil
+	}
+
+	for _, t := range tasks.([]*task.Task) {
+		for i := range t.Members {
+			if t.Members[i].ID.String() == id {
+				return &t.Members[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// groupOwns reports whether a container belongs to the pod of a known task
+// group, either as one of its members or as the pod itself.
+func (w *Worker) groupOwns(c task.ContainerSummary) bool {
+	id := c.Labels[task.LabelTaskID]
+	result, err := w.Db.Get(id)
+	if err == nil {
+		return len(result.(*task.Task).Members) > 0
+	}
+
+	m := w.findMember(id)
+	return m != nil && m.ContainerID == c.ID
+}
+
+func hasProbes(t *task.Task) bool {
+	liveness, readiness := t.Probes()
+	return liveness != nil || readiness != nil
+}
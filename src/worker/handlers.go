@@ -2,11 +2,17 @@
 package worker
 
 import (
+	"cube/stream"
 	"cube/task"
+	"cube/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -52,13 +58,33 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 	if taskID == "" {
 		log.Printf("No taskID passed in request.\n")
 		w.WriteHeader(400)
+		return
 	}
 
 	tID, _ := uuid.Parse(taskID)
 	t, err := a.Worker.Db.Get(tID.String())
+	// The manager removes finished tasks it no longer keeps.
+	if r.URL.Query().Get("remove") == "true" {
+		if err != nil {
+			writeError(w, 404, fmt.Sprintf("No task with ID %v found", tID))
+			return
+		}
+		err := a.Worker.RemoveTask(tID.String())
+		if err != nil {
+			writeError(w, 409, err.Error())
+			return
+		}
+		log.Printf("Removed task %v\n", tID)
+		w.WriteHeader(204)
+		return
+	}
 	if err != nil {
-		log.Printf("No task with ID %v found", tID)
-		w.WriteHeader(404)
+		// The task may not have been started yet. Requests are handled in
+		// order, so queue the stop behind the start.
+		log.Printf("No task with ID %v found, stopping it if it is queued\n", tID)
+		a.Worker.AddTask(task.Task{ID: tID, State: task.Completed})
+		w.WriteHeader(204)
+		return
 	}
 
 	taskToStop := t.(*task.Task)
@@ -69,8 +95,191 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(204)
 }
 
+func (a *Api) PullImagesHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	req := ImagePullRequest{}
+	err := d.Decode(&req)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	if len(req.Images) == 0 {
+		writeError(w, 400, "No images given")
+		return
+	}
+
+	results, err := a.Worker.PullImages(req.Images)
+	if err != nil {
+		writeError(w, 501, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(results)
+}
+
+func (a *Api) GetImagesHandler(w http.ResponseWriter, r *http.Request) {
+	images, err := a.Worker.ListImages()
+	if errors.Is(err, ErrNoImageStore) {
+		writeError(w, 501, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, 500, fmt.Sprintf("Error listing images: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(images)
+}
+
 func (a *Api) GetStatsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
 	json.NewEncoder(w).Encode(a.Worker.Stats)
 }
+
+func (a *Api) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "taskID")
+	tID, err := uuid.Parse(taskID)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid taskID %s: %v", taskID, err))
+		return
+	}
+
+	t, err := a.Worker.GetTask(tID.String())
+	if err != nil {
+		writeError(w, 404, fmt.Sprintf("No task with ID %v found", tID))
+		return
+	}
+	if len(t.Members) > 0 {
+		writeError(w, 400, fmt.Sprintf("Task %v is a task group; use the ID of one of its members", tID))
+		return
+	}
+
+	opts, err := parseLogOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+
+	// Once the header is written failures can only end the stream, so make
+	// sure there is a container to read from first.
+	if t.ContainerID == "" {
+		writeError(w, 404, fmt.Sprintf("Task %v has no container", tID))
+		return
+	}
+	resp := a.Worker.InspectTask(*t)
+	if resp.Error != nil {
+		writeError(w, 404, fmt.Sprintf("Error inspecting container of task %v: %v", tID, resp.Error))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(200)
+	err = a.Worker.Runtime.Logs(r.Context(), t.ContainerID, opts, utils.FlushWriter{W: w})
+	if err != nil {
+		log.Printf("Error streaming logs for task %v: %v\n", tID, err)
+	}
+}
+
+func (a *Api) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := chi.URLParam(r, "taskID")
+	tID, err := uuid.Parse(taskID)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Invalid taskID %s: %v", taskID, err))
+		return
+	}
+
+	t, err := a.Worker.GetTask(tID.String())
+	if err != nil {
+		writeError(w, 404, fmt.Sprintf("No task with ID %v found", tID))
+		return
+	}
+	if len(t.Members) > 0 {
+		writeError(w, 400, fmt.Sprintf("Task %v is a task group; use the ID of one of its members", tID))
+		return
+	}
+
+	if r.Header.Get("Upgrade") != stream.Protocol {
+		writeError(w, 400, fmt.Sprintf("Exec requires the %s upgrade protocol", stream.Protocol))
+		return
+	}
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	req := ExecRequest{}
+	err = d.Decode(&req)
+	if err != nil {
+		writeError(w, 400, fmt.Sprintf("Error unmarshalling body: %v", err))
+		return
+	}
+	if len(req.Cmd) == 0 {
+		writeError(w, 400, "No command given")
+		return
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeError(w, 500, fmt.Sprintf("Error upgrading connection: %v", err))
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", stream.Protocol)
+	err = brw.Flush()
+	if err != nil {
+		log.Printf("Error upgrading connection: %v\n", err)
+		return
+	}
+
+	a.Worker.ExecTask(*t, req, brw.Reader, conn)
+}
+
+// parseLogOptions reads the follow, tail and since query parameters. Since
+// is either an RFC 3339 timestamp or a duration such as 10m, counted back
+// from now.
+func parseLogOptions(query url.Values) (task.LogOptions, error) {
+	opts := task.LogOptions{Tail: -1}
+
+	if follow := query.Get("follow"); follow != "" {
+		f, err := strconv.ParseBool(follow)
+		if err != nil {
+			return opts, fmt.Errorf("invalid follow value %q", follow)
+		}
+		opts.Follow = f
+	}
+
+	if tail := query.Get("tail"); tail != "" && tail != "all" {
+		n, err := strconv.Atoi(tail)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("invalid tail value %q", tail)
+		}
+		opts.Tail = n
+	}
+
+	if since := query.Get("since"); since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			opts.Since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, since); err == nil {
+			opts.Since = t
+		} else {
+			return opts, fmt.Errorf("invalid since value %q", since)
+		}
+	}
+
+	return opts, nil
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	log.Print(msg)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(ErrResponse{
+		HTTPStatusCode: code,
+		Message:        msg,
+	})
+}
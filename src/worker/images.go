@@ -0,0 +1,190 @@
+package worker
+
+import (
+	"cube/stats"
+	"cube/task"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrNoImageStore is returned when the worker's runtime has no image cache.
+var ErrNoImageStore = errors.New("runtime does not manage images")
+
+// ImagePullRequest is the body of a request to pre-pull images.
+type ImagePullRequest struct {
+	Images []string
+}
+
+// ImagePullResult reports the outcome of pulling one image. Error is empty
+// when the pull succeeded.
+type ImagePullResult struct {
+	Image string
+	Error string
+}
+
+// PullImages pulls each image into the runtime's cache, so tasks using them
+// can start without waiting on the registry.
+func (w *Worker) PullImages(images []string) ([]ImagePullResult, error) {
+	store, ok := w.Runtime.(task.ImageStore)
+	if !ok {
+		return nil, ErrNoImageStore
+	}
+
+	var results []ImagePullResult
+	for _, image := range images {
+		log.Printf("Pulling image %s\n", image)
+		result := ImagePullResult{Image: image}
+		err := store.PullImage(image)
+		if err != nil {
+			log.Printf("Error pulling image %s: %v\n", image, err)
+			result.Error = err.Error()
+		} else {
+			w.useImage(image)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (w *Worker) ListImages() ([]task.ImageSummary, error) {
+	store, ok := w.Runtime.(task.ImageStore)
+	if !ok {
+		return nil, ErrNoImageStore
+	}
+
+	return store.ListImages()
+}
+
+// useImage records that image was just used by a task or pulled on request.
+func (w *Worker) useImage(image string) {
+	w.imagesMu.Lock()
+	defer w.imagesMu.Unlock()
+
+	w.imagesUsed[task.NormalizeImage(image)] = time.Now()
+}
+
+// imageLastUsed returns when any of the image's names was last used. Images
+// the worker has not seen used since it started count as used at startup.
+func (w *Worker) imageLastUsed(image task.ImageSummary) time.Time {
+	w.imagesMu.Lock()
+	defer w.imagesMu.Unlock()
+
+	last := w.started
+	for _, name := range image.Names {
+		if used, ok := w.imagesUsed[name]; ok && used.After(last) {
+			last = used
+		}
+	}
+
+	return last
+}
+
+func (w *Worker) CollectImages() {
+	for {
+		w.collectImages()
+		time.Sleep(time.Minute)
+	}
+}
+
+// collectImages removes images that no task has used for ImageTTL, but only
+// while disk usage is above ImageGCThreshold percent. Images of scheduled
+// and running tasks are never removed.
+func (w *Worker) collectImages() {
+	store, ok := w.Runtime.(task.ImageStore)
+	if !ok || w.ImageGCThreshold <= 0 {
+		return
+	}
+	if diskUsedPercent() < w.ImageGCThreshold {
+		return
+	}
+
+	inUse, err := w.imagesInUse()
+	if err != nil {
+		log.Printf("Error finding images in use: %v\n", err)
+		return
+	}
+	images, err := store.ListImages()
+	if err != nil {
+		log.Printf("Error listing images: %v\n", err)
+		return
+	}
+
+	log.Printf("Disk usage is above %.1f%%, removing images unused for %v\n", w.ImageGCThreshold, w.ImageTTL)
+	for _, image := range images {
+		if diskUsedPercent() < w.ImageGCThreshold {
+			return
+		}
+		if imageInUse(image, inUse) || time.Since(w.imageLastUsed(image)) < w.ImageTTL {
+			continue
+		}
+
+		log.Printf("Removing image %s %v\n", image.ID, image.Names)
+		err := store.RemoveImage(image.ID)
+		if err != nil {
+			log.Printf("Error removing image %s: %v\n", image.ID, err)
+		}
+	}
+}
+
+func (w *Worker) imagesInUse() (map[string]bool, error) {
+	tasks, err := w.Db.List()
+	if err != nil {
+		return nil, err
+	}
+
+	inUse := make(map[string]bool)
+	for _, t := range tasks.([]*task.Task) {
+		if imagesNeeded(t) {
+			addImages(inUse, t)
+		}
+	}
+
+	return inUse, nil
+}
+
+// imagesNeeded reports whether a task's images must be kept: it is running
+// or about to, or it has finished and will be restarted.
+func imagesNeeded(t *task.Task) bool {
+	switch t.State {
+	case task.Scheduled, task.Running, task.Stopping:
+		return true
+	case task.Completed, task.Failed:
+		return t.RestartPolicy.ShouldRestart(t)
+	}
+
+	return false
+}
+
+// addImages adds the images of a task, its init containers and its members.
+func addImages(inUse map[string]bool, t *task.Task) {
+	if t.Image != "" {
+		inUse[task.NormalizeImage(t.Image)] = true
+	}
+	for i := range t.InitContainers {
+		addImages(inUse, &t.InitContainers[i])
+	}
+	for i := range t.Members {
+		addImages(inUse, &t.Members[i].Task)
+	}
+}
+
+func imageInUse(image task.ImageSummary, inUse map[string]bool) bool {
+	for _, name := range image.Names {
+		if inUse[name] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func diskUsedPercent() float64 {
+	disk := stats.GetDiskInfo()
+	if disk.All == 0 {
+		return 0
+	}
+
+	return float64(disk.Used) / float64(disk.All) * 100
+}
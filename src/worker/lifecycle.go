@@ -0,0 +1,117 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"cube/task"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// postStartTimeout bounds how long a post-start hook may run. Pre-stop hooks
+// get the task's grace period instead, before the stop's own.
+const postStartTimeout = 30 * time.Second
+
+// initContainerTimeout bounds how long an init container may run. Tasks are
+// started one at a time, so one that never exits would hold up the rest.
+const initContainerTimeout = 10 * time.Minute
+
+// runInitContainers runs the task's init containers in order, each to
+// completion, and stops at the first one that fails. Each container is
+// removed once it has exited.
+func (w *Worker) runInitContainers(t *task.Task) error {
+	for i := range t.InitContainers {
+		init := &t.InitContainers[i]
+		if init.ID == uuid.Nil {
+			init.ID = uuid.New()
+		}
+		if init.Name == "" {
+			init.Name = fmt.Sprintf("%d", i)
+		}
+
+		c := task.NewConfig(init)
+		c.Name = fmt.Sprintf("%s-init-%s", t.Name, init.Name)
+		c.Labels = map[string]string{
+			task.LabelInitFor: t.ID.String(),
+			task.LabelWorker:  w.Name,
+		}
+		w.useImage(init.Image)
+
+		log.Printf("Running init container %s for task %v\n", c.Name, t.ID)
+		init.StartTime = time.Now().UTC()
+		result := w.Runtime.Run(c)
+		if result.Error != nil {
+			init.State = task.Failed
+			init.TerminationMessage = result.Error.Error()
+			return fmt.Errorf("init container %s failed to start: %w", init.Name, result.Error)
+		}
+		init.ContainerID = result.ContainerId
+
+		ctx, cancel := context.WithTimeout(context.Background(), initContainerTimeout)
+		code, err := w.Runtime.Wait(ctx, result.ContainerId)
+		cancel()
+		init.FinishTime = time.Now().UTC()
+		init.ExitCode = code
+		w.Runtime.Stop(result.ContainerId)
+		if ctx.Err() == context.DeadlineExceeded {
+			init.State = task.Failed
+			init.TerminationMessage = fmt.Sprintf("did not finish within %v", initContainerTimeout)
+			return fmt.Errorf("init container %s did not finish within %v", init.Name, initContainerTimeout)
+		}
+		if err != nil {
+			init.State = task.Failed
+			init.TerminationMessage = err.Error()
+			return fmt.Errorf("error waiting for init container %s: %w", init.Name, err)
+		}
+		if code != 0 {
+			init.State = task.Failed
+			init.TerminationMessage = fmt.Sprintf("exited with code %d", code)
+			return fmt.Errorf("init container %s exited with code %d", init.Name, code)
+		}
+		init.State = task.Completed
+	}
+
+	return nil
+}
+
+// runHook runs a lifecycle hook command in the task's container. A hook
+// fails if it cannot be run, exits non-zero or is still running at timeout.
+func (w *Worker) runHook(t *task.Task, name string, cmd []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	log.Printf("Running %s hook %v for task %v\n", name, cmd, t.ID)
+	var out bytes.Buffer
+	code, err := w.Runtime.Exec(ctx, t.ContainerID, task.ExecOptions{Cmd: cmd, Stdout: &out, Stderr: &out})
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s hook did not finish within %v", name, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %w", name, err)
+	}
+	if code != 0 {
+		return exitError(name+" hook", code, out.String())
+	}
+
+	return nil
+}
+
+// exitError describes a command that exited non-zero, with the end of its
+// output, which is usually where the error is.
+func exitError(what string, code int, out string) error {
+	const max = 200
+
+	out = strings.TrimSpace(out)
+	if out == "" {
+		return fmt.Errorf("%s exited with code %d", what, code)
+	}
+	if len(out) > max {
+		out = "..." + out[len(out)-max:]
+	}
+
+	return fmt.Errorf("%s exited with code %d: %s", what, code, out)
+}
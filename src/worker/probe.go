@@ -0,0 +1,248 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"cube/task"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// prober tracks the probes of one running task. It is reset whenever the
+// task gets a new container.
+type prober struct {
+	containerID string
+	liveness    probeState
+	readiness   probeState
+}
+
+type probeState struct {
+	status  task.ProbeStatus
+	next    time.Time
+	running bool
+}
+
+// RunProbes runs the liveness and readiness probes of running tasks. Their
+// status is reported to the manager along with the tasks.
+func (w *Worker) RunProbes() {
+	for {
+		w.runProbes()
+		time.Sleep(time.Second)
+	}
+}
+
+// runProbes starts every probe that is due. Probes run concurrently so a
+// slow one does not hold up the others.
+func (w *Worker) runProbes() {
+	tasks, err := w.Db.List()
+	if err != nil {
+		log.Printf("error getting list of tasks: %v\n", err)
+		return
+	}
+
+	w.probesMu.Lock()
+	defer w.probesMu.Unlock()
+
+	probed := make(map[uuid.UUID]bool)
+	for _, t := range tasks.([]*task.Task) {
+		liveness, readiness := t.Probes()
+		if t.State != task.Running || t.ContainerID == "" || t.JobID != uuid.Nil || (liveness == nil && readiness == nil) {
+			continue
+		}
+		probed[t.ID] = true
+
+		p, ok := w.probes[t.ID]
+		if !ok || p.containerID != t.ContainerID {
+			p = newProber(t, liveness, readiness)
+			w.probes[t.ID] = p
+		}
+		if liveness != nil {
+			w.startProbe(*t, "liveness", liveness, &p.liveness)
+		}
+		if readiness != nil {
+			w.startProbe(*t, "readiness", readiness, &p.readiness)
+		}
+	}
+
+	for id := range w.probes {
+		if !probed[id] {
+			delete(w.probes, id)
+		}
+	}
+}
+
+// newProber starts a task's probes off after their initial delay. A task is
+// taken to be live until its liveness probe fails, but not ready until its
+// readiness probe passes.
+func newProber(t *task.Task, liveness *task.Probe, readiness *task.Probe) *prober {
+	p := &prober{containerID: t.ContainerID}
+	p.liveness.status.Passing = true
+	if liveness != nil {
+		p.liveness.next = t.StartTime.Add(time.Duration(liveness.InitialDelay) * time.Second)
+	}
+	if readiness != nil {
+		p.readiness.next = t.StartTime.Add(time.Duration(readiness.InitialDelay) * time.Second)
+	}
+
+	return p
+}
+
+// startProbe runs the probe in the background if it is due. The caller must
+// hold probesMu.
+func (w *Worker) startProbe(t task.Task, name string, probe *task.Probe, s *probeState) {
+	now := time.Now()
+	if s.running || now.Before(s.next) {
+		return
+	}
+	s.running = true
+	s.next = now.Add(probe.Period())
+
+	go func() {
+		err := w.probe(&t, probe)
+
+		w.probesMu.Lock()
+		defer w.probesMu.Unlock()
+		s.running = false
+		passing := s.status.Passing
+		recordProbe(probe, &s.status, err)
+		if s.status.Passing != passing {
+			log.Printf("%s probe for task %v is now passing=%v: %s\n", name, t.ID, s.status.Passing, s.status.Message)
+		}
+	}()
+}
+
+// recordProbe counts the result of a probe and changes the verdict once a
+// threshold is reached.
+func recordProbe(probe *task.Probe, s *task.ProbeStatus, err error) {
+	success, failure := probe.Thresholds()
+	s.LastProbe = time.Now().UTC()
+	if err == nil {
+		s.Successes++
+		s.Failures = 0
+		if s.Successes >= success {
+			s.Passing = true
+			s.Message = ""
+		}
+		return
+	}
+
+	s.Failures++
+	s.Successes = 0
+	s.Message = err.Error()
+	if s.Failures >= failure {
+		s.Passing = false
+	}
+}
+
+// probeStatus returns the latest status of a task's probes, if they are
+// running.
+func (w *Worker) probeStatus(t *task.Task) (liveness task.ProbeStatus, readiness task.ProbeStatus, ok bool) {
+	w.probesMu.Lock()
+	defer w.probesMu.Unlock()
+
+	p, ok := w.probes[t.ID]
+	if !ok || p.containerID != t.ContainerID {
+		return liveness, readiness, false
+	}
+
+	return p.liveness.status, p.readiness.status, true
+}
+
+// probe runs a single probe against the task, returning why it failed.
+func (w *Worker) probe(t *task.Task, probe *task.Probe) error {
+	timeout := probe.TimeoutDuration()
+
+	switch {
+	case probe.HTTPGet != nil:
+		addr, err := probeAddress(t, probe.HTTPGet.Port)
+		if err != nil {
+			return err
+		}
+		path := probe.HTTPGet.Path
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		url := fmt.Sprintf("http://%s%s", addr, path)
+
+		client := http.Client{Timeout: timeout}
+		resp, err := client.Get(url)
+		if err != nil {
+			return fmt.Errorf("GET %s failed: %w", url, err)
+		}
+		resp.Body.Close()
+
+		expected := probe.HTTPGet.ExpectedStatus
+		if expected != 0 && resp.StatusCode != expected {
+			return fmt.Errorf("GET %s returned %d, expected %d", url, resp.StatusCode, expected)
+		}
+		if expected == 0 && (resp.StatusCode < 200 || resp.StatusCode >= 400) {
+			return fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+		}
+		return nil
+
+	case probe.TCPSocket != nil:
+		addr, err := probeAddress(t, probe.TCPSocket.Port)
+		if err != nil {
+			return err
+		}
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return fmt.Errorf("connecting to %s failed: %w", addr, err)
+		}
+		conn.Close()
+		return nil
+
+	default:
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		var out bytes.Buffer
+		code, err := w.Runtime.Exec(ctx, t.ContainerID, task.ExecOptions{Cmd: probe.Exec, Stdout: &out, Stderr: &out})
+		if ctx.Err() != nil {
+			return fmt.Errorf("%v did not finish within %v", probe.Exec, timeout)
+		}
+		if err != nil {
+			return fmt.Errorf("running %v failed: %w", probe.Exec, err)
+		}
+		if code != 0 {
+			return exitError(fmt.Sprintf("%v", probe.Exec), code, out.String())
+		}
+		return nil
+	}
+}
+
+// probeAddress returns where the worker can reach a container port: the host
+// port it is published on, or else the port itself on the worker, which is
+// where tasks run by the process runtime listen.
+func probeAddress(t *task.Task, port uint16) (string, error) {
+	for _, m := range t.ExposedPorts {
+		if m.ContainerPort == port && m.HostPort != 0 {
+			return net.JoinHostPort(probeHost(m.HostIP), strconv.Itoa(int(m.HostPort))), nil
+		}
+	}
+
+	if len(t.HostPorts) > 0 {
+		bindings := t.HostPorts[fmt.Sprintf("%d/tcp", port)]
+		if len(bindings) == 0 || bindings[0].HostPort == "" {
+			return "", fmt.Errorf("port %d is not published", port)
+		}
+		return net.JoinHostPort(probeHost(bindings[0].HostIP), bindings[0].HostPort), nil
+	}
+
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))), nil
+}
+
+func probeHost(ip string) string {
+	if ip == "" || ip == "0.0.0.0" || ip == "::" {
+		return "127.0.0.1"
+	}
+
+	return ip
+}
@@ -7,23 +7,50 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/containers/podman/v5/libpod/define"
 	"github.com/golang-collections/collections/queue"
+	"github.com/google/uuid"
+)
+
+// Policies for containers found at startup that belong to no known task.
+const (
+	OrphanRemove = "remove"
+	OrphanKeep   = "keep"
 )
 
 type Worker struct {
-	Name      string
-	Queue     queue.Queue
-	Db        store.Store
-	TaskCount int
-	Stats     *stats.Stats
+	Name         string
+	Queue        queue.Queue
+	Db           store.Store
+	TaskCount    int
+	Stats        *stats.Stats
+	Runtime      task.Runtime
+	OrphanPolicy string
+	// AllowPrivileged lets tasks request a privileged container
+	AllowPrivileged bool
+	// ImageTTL is how long an image must go unused before it may be
+	// removed, and only once disk usage is above ImageGCThreshold percent
+	ImageTTL         time.Duration
+	ImageGCThreshold float64
+
+	started    time.Time
+	imagesMu   sync.Mutex
+	imagesUsed map[string]time.Time
+	probesMu   sync.Mutex
+	probes     map[uuid.UUID]*prober
 }
 
-func New(name string, taskDbType string) *Worker {
+func New(name string, taskDbType string, runtime task.Runtime) *Worker {
 	w := Worker{
-		Name:  name,
-		Queue: *queue.New(),
+		Name:       name,
+		Queue:      *queue.New(),
+		Runtime:    runtime,
+		started:    time.Now(),
+		imagesUsed: make(map[string]time.Time),
+		probes:     make(map[uuid.UUID]*prober),
 	}
 	var s store.Store
 	switch taskDbType {
@@ -37,6 +64,8 @@ func New(name string, taskDbType string) *Worker {
 	return &w
 }
 
+// GetTasks lists the worker's tasks along with the latest status of their
+// probes.
 func (w *Worker) GetTasks() []*task.Task {
 	taskList, err := w.Db.List()
 	if err != nil {
@@ -44,7 +73,20 @@ func (w *Worker) GetTasks() []*task.Task {
 		return nil
 	}
 
-	return taskList.([]*task.Task)
+	tasks := taskList.([]*task.Task)
+	for i, t := range tasks {
+		liveness, readiness, ok := w.probeStatus(t)
+		if !ok {
+			continue
+		}
+		// Copy the task rather than change the one in the store.
+		c := *t
+		c.Liveness = liveness
+		c.Readiness = readiness
+		tasks[i] = &c
+	}
+
+	return tasks
 }
 
 func (w *Worker) AddTask(t task.Task) {
@@ -60,16 +102,8 @@ func (w *Worker) CollectStats() {
 	}
 }
 
-func (w *Worker) InspectTask(t task.Task) task.PodmanInspectResponse {
-	config := task.NewConfig(&t)
-
-	p, err := task.NewPodman(config)
-	if err != nil {
-		log.Printf("error getting creating Podman connection: %v\n", err)
-		return task.PodmanInspectResponse{Error: err}
-	}
-
-	return p.Inspect(t.ContainerID)
+func (w *Worker) InspectTask(t task.Task) task.InspectResponse {
+	return w.Runtime.Inspect(t.ContainerID)
 }
 
 func (w *Worker) UpdateTasks() {
@@ -101,27 +135,54 @@ func (w *Worker) RunTasks() {
 
 func (w *Worker) StartTask(t task.Task) task.ContainerResult {
 	t.StartTime = time.Now().UTC()
+	// Clear how a previous run of the task ended.
+	t.FinishTime = time.Time{}
+	t.ExitCode = 0
+	t.OOMKilled = false
+	t.TerminationMessage = ""
+	t.Reason = ""
+
+	if t.SecurityContext.Privileged && !w.AllowPrivileged {
+		return w.failTask(&t, fmt.Errorf("privileged tasks are not allowed on worker %s", w.Name))
+	}
+	if len(t.Members) > 0 {
+		return w.startGroup(t)
+	}
+	if t.LivenessProbe != nil {
+		if err := t.LivenessProbe.Validate(); err != nil {
+			return w.failTask(&t, fmt.Errorf("invalid liveness probe: %w", err))
+		}
+	}
+	if t.ReadinessProbe != nil {
+		if err := t.ReadinessProbe.Validate(); err != nil {
+			return w.failTask(&t, fmt.Errorf("invalid readiness probe: %w", err))
+		}
+	}
 
-	config := task.NewConfig(&t)
-
-	p, err := task.NewPodman(config)
+	err := w.runInitContainers(&t)
 	if err != nil {
-		log.Printf("Error creating podman connection: %v", err)
-		return task.ContainerResult{Error: err}
+		t.Reason = task.ReasonInitContainerFailed
+		return w.failTask(&t, err)
 	}
 
-	result := p.Run()
+	config := task.NewConfig(&t)
+	config.Labels = w.labels(&t)
+
+	w.useImage(t.Image)
+	result := w.Runtime.Run(config)
 	if result.Error != nil {
-		log.Printf("Err running task %v: %v\n", t.ID, result.Error)
-		t.State = task.Failed
-		err := w.Db.Put(t.ID.String(), &t)
-		if err != nil {
-			fmt.Printf("Error updating task: %v", err)
-		}
-		return result
+		return w.failTask(&t, result.Error)
 	}
 
 	t.ContainerID = result.ContainerId
+	if len(t.PostStart) > 0 {
+		err := w.runHook(&t, "post-start", t.PostStart, postStartTimeout)
+		if err != nil {
+			w.Runtime.Stop(t.ContainerID)
+			t.Reason = task.ReasonPostStartHookFailed
+			return w.failTask(&t, err)
+		}
+	}
 	t.State = task.Running
 	err = w.Db.Put(t.ID.String(), &t)
 	if err != nil {
@@ -131,15 +192,137 @@ func (w *Worker) StartTask(t task.Task) task.ContainerResult {
 	return result
 }
 
-func (w *Worker) StopTask(t task.Task) task.ContainerResult {
-	config := task.NewConfig(&t)
+// failTask marks a task that could not be started as failed.
+func (w *Worker) failTask(t *task.Task, err error) task.ContainerResult {
+	log.Printf("Err running task %v: %v\n", t.ID, err)
+	t.State = task.Failed
+	t.FinishTime = time.Now().UTC()
+	t.TerminationMessage = err.Error()
+	perr := w.Db.Put(t.ID.String(), t)
+	if perr != nil {
+		fmt.Printf("Error updating task: %v", perr)
+	}
+
+	return task.ContainerResult{Error: err}
+}
+
+// labels returns the labels put on a task's container.
+func (w *Worker) labels(t *task.Task) map[string]string {
+	return map[string]string{
+		task.LabelTaskID:   t.ID.String(),
+		task.LabelWorker:   w.Name,
+		task.LabelSpecHash: task.SpecHash(t),
+	}
+}
+
+// Reconcile matches the containers this worker created against its task
+// store, normally once at startup. Containers for known tasks are adopted
+// again; the rest are orphans and are handled according to OrphanPolicy.
+func (w *Worker) Reconcile() error {
+	containers, err := w.Runtime.List()
+	if err != nil {
+		return fmt.Errorf("error listing containers: %w", err)
+	}
+
+	for _, c := range containers {
+		if c.Labels[task.LabelWorker] != w.Name {
+			continue
+		}
+		// An init container left running when the worker stopped is never
+		// waited for again.
+		if c.Labels[task.LabelInitFor] != "" {
+			log.Printf("Removing init container %v of task %v\n", c.ID, c.Labels[task.LabelInitFor])
+			result := w.Runtime.Stop(c.ID)
+			if result.Error != nil {
+				log.Printf("Error removing init container %v: %v\n", c.ID, result.Error)
+			}
+			continue
+		}
+		if w.groupOwns(c) {
+			log.Printf("Adopted container %v of task group member %v\n", c.ID, c.Labels[task.LabelTaskID])
+			continue
+		}
+
+		t := w.knownTask(c)
+		if t == nil {
+			w.handleOrphan(c)
+			continue
+		}
+
+		if t.ContainerID == "" {
+			t.ContainerID = c.ID
+		}
+		if c.Status == "running" && t.State == task.Scheduled {
+			t.State = task.Running
+		}
+		err := w.Db.Put(t.ID.String(), t)
+		if err != nil {
+			log.Printf("Error updating task %v: %v\n", t.ID, err)
+			continue
+		}
+		log.Printf("Adopted container %v for task %v\n", c.ID, t.ID)
+	}
+
+	return nil
+}
+
+// knownTask returns the stored task a container was created for, or nil if
+// the container is not the one the task is using.
+func (w *Worker) knownTask(c task.ContainerSummary) *task.Task {
+	result, err := w.Db.Get(c.Labels[task.LabelTaskID])
+	if err != nil {
+		return nil
+	}
+	t := result.(*task.Task)
+
+	if t.ContainerID == c.ID {
+		return t
+	}
+	// The worker may have stopped between starting the container and
+	// saving its ID, so accept it if it was created from the same spec.
+	if t.ContainerID == "" && c.Labels[task.LabelSpecHash] == task.SpecHash(t) {
+		return t
+	}
 
-	p, err := task.NewPodman(config)
+	return nil
+}
+
+func (w *Worker) handleOrphan(c task.ContainerSummary) {
+	switch w.OrphanPolicy {
+	case OrphanKeep:
+		log.Printf("Keeping orphaned container %v (task %v)\n", c.ID, c.Labels[task.LabelTaskID])
+	default:
+		log.Printf("Removing orphaned container %v (task %v)\n", c.ID, c.Labels[task.LabelTaskID])
+		result := w.Runtime.Stop(c.ID)
+		if result.Error != nil {
+			log.Printf("Error removing orphaned container %v: %v\n", c.ID, result.Error)
+		}
+	}
+}
+
+func (w *Worker) StopTask(t task.Task) task.ContainerResult {
+	// Stopping waits out the task's grace period, so make the task's state
+	// visible to the manager while it shuts down.
+	t.State = task.Stopping
+	err := w.Db.Put(t.ID.String(), &t)
 	if err != nil {
-		log.Printf("Error creating podman connection: %v", err)
-		return task.ContainerResult{Error: err}
+		fmt.Printf("Error updating task: %v", err)
+	}
+
+	// The pre-stop hook gets a grace period of its own, and the container
+	// still gets its full grace period after the stop signal, so stopping
+	// may take up to twice the grace period.
+	var hookErr error
+	if len(t.PreStop) > 0 {
+		hookErr = w.runHook(&t, "pre-stop", t.PreStop, task.NewConfig(&t).GracePeriod())
+	}
+
+	var result task.ContainerResult
+	if len(t.Members) > 0 {
+		result = w.stopGroup(&t)
+	} else {
+		result = w.Runtime.Stop(t.ContainerID)
 	}
-	result := p.Stop(t.ContainerID)
 	if result.Error != nil {
 		log.Printf("Error stopping container %v: %v\n", t.ContainerID,
 			result.Error)
@@ -147,6 +330,14 @@ func (w *Worker) StopTask(t task.Task) task.ContainerResult {
 
 	t.FinishTime = time.Now().UTC()
 	t.State = task.Completed
+	t.Reason = task.ReasonStopped
+	t.TerminationMessage = "stopped"
+	if hookErr != nil {
+		log.Printf("Error stopping task %v: %v\n", t.ID, hookErr)
+		t.State = task.Failed
+		t.Reason = task.ReasonPreStopHookFailed
+		t.TerminationMessage = hookErr.Error()
+	}
 	err = w.Db.Put(t.ID.String(), &t)
 	if err != nil {
 		fmt.Printf("Error updating task: %v", err)
@@ -158,6 +349,43 @@ func (w *Worker) StopTask(t task.Task) task.ContainerResult {
 	return result
 }
 
+// removeContainer removes the container a finished task left behind. A
+// task that was stopped has had its container removed already.
+func (w *Worker) removeContainer(t task.Task) {
+	if t.ContainerID == "" {
+		return
+	}
+
+	var result task.ContainerResult
+	if len(t.Members) > 0 {
+		result = w.stopGroup(&t)
+	} else {
+		if w.InspectTask(t).Container == nil {
+			return
+		}
+		result = w.Runtime.Stop(t.ContainerID)
+	}
+	if result.Error != nil {
+		log.Printf("Error removing container %v of task %v: %v\n", t.ContainerID, t.ID, result.Error)
+	}
+}
+
+// RemoveTask removes a finished task's container and forgets the task. The
+// manager asks for this once it no longer keeps the task itself.
+func (w *Worker) RemoveTask(id string) error {
+	result, err := w.Db.Get(id)
+	if err != nil {
+		return err
+	}
+	t := result.(*task.Task)
+	if t.State != task.Completed && t.State != task.Failed {
+		return fmt.Errorf("task %s is %v and has not finished", id, t.State)
+	}
+
+	w.removeContainer(*t)
+	return w.Db.Delete(id)
+}
+
 func (w *Worker) runTask() task.ContainerResult {
 	t := w.Queue.Dequeue()
 	if t == nil {
@@ -166,28 +394,49 @@ func (w *Worker) runTask() task.ContainerResult {
 	}
 
 	taskQueued := t.(task.Task)
-	err := w.Db.Put(taskQueued.ID.String(), &taskQueued)
-	if err != nil {
-		msg := fmt.Errorf("error storing task %s: %w", taskQueued.ID.String(), err)
-		log.Println(msg)
-		return task.ContainerResult{Error: msg}
-	}
+
+	// Compare against the stored state before it is overwritten, otherwise a
+	// stop request would always look like a Completed to Completed transition.
+	var taskPersisted task.Task
 	queuedTask, err := w.Db.Get(taskQueued.ID.String())
 	if err != nil {
-		msg := fmt.Errorf("error getting task %s from database: %w", taskQueued.ID.String(), err)
-		log.Println(msg)
-		return task.ContainerResult{Error: msg}
+		if taskQueued.State == task.Completed {
+			msg := fmt.Errorf("no task %s to stop", taskQueued.ID.String())
+			log.Println(msg)
+			return task.ContainerResult{Error: msg}
+		}
+		err = w.Db.Put(taskQueued.ID.String(), &taskQueued)
+		if err != nil {
+			msg := fmt.Errorf("error storing task %s: %w", taskQueued.ID.String(), err)
+			log.Println(msg)
+			return task.ContainerResult{Error: msg}
+		}
+		taskPersisted = taskQueued
+	} else {
+		taskPersisted = *queuedTask.(*task.Task)
 	}
-	taskPersisted := *queuedTask.(*task.Task)
 
 	var result task.ContainerResult
 
 	if task.ValidStateTransition(taskPersisted.State, taskQueued.State) {
 		switch taskQueued.State {
 		case task.Scheduled:
+			// The manager restarts a running task whose liveness probe is
+			// failing, so its old container has to go first. A finished
+			// task's exited container would keep the name in use.
+			switch taskPersisted.State {
+			case task.Running:
+				w.StopTask(taskPersisted)
+			case task.Completed, task.Failed:
+				w.removeContainer(taskPersisted)
+			}
 			result = w.StartTask(taskQueued)
 		case task.Completed:
-			result = w.StopTask(taskQueued)
+			// Stop the container as last recorded, since a stop sent
+			// before the task started carries only its ID.
+			stop := taskPersisted
+			stop.State = task.Completed
+			result = w.StopTask(stop)
 		default:
 			result.Error = errors.New("we should not get here")
 		}
@@ -206,29 +455,13 @@ func (w *Worker) updateTasks() {
 		return
 	}
 
-	for id, t := range tasks.([]*task.Task) {
+	for _, t := range tasks.([]*task.Task) {
 		if t.State == task.Running {
-			resp := w.InspectTask(*t)
-			if resp.Error != nil {
-				fmt.Printf("ERROR: %v\n", resp.Error)
-			}
-			if resp.Container == nil {
-				log.Printf("No container for running task %d\n", id)
-				t.State = task.Failed
-				err := w.Db.Put(t.ID.String(), t)
-				if err != nil {
-					fmt.Printf("Error updating task: %v", err)
-				}
-			}
-			if resp.Container.State.Status == "exited" {
-				log.Printf("Container for task %d in non-running state %s", id, resp.Container.State.Status)
-				t.State = task.Failed
-				err := w.Db.Put(t.ID.String(), t)
-				if err != nil {
-					fmt.Printf("Error updating task: %v", err)
-				}
+			if len(t.Members) > 0 {
+				w.updateGroup(t)
+			} else {
+				w.refreshTask(t)
 			}
-			t.HostPorts = resp.Container.NetworkSettings.Ports
 			err := w.Db.Put(t.ID.String(), t)
 			if err != nil {
 				fmt.Printf("Error updating task: %v", err)
@@ -236,3 +469,57 @@ func (w *Worker) updateTasks() {
 		}
 	}
 }
+
+// refreshTask updates a running task from its container, recording how it
+// ended if it has exited.
+func (w *Worker) refreshTask(t *task.Task) {
+	resp := w.InspectTask(*t)
+	if resp.Error != nil {
+		fmt.Printf("ERROR: %v\n", resp.Error)
+	}
+	if resp.Container == nil {
+		log.Printf("No container for running task %v\n", t.ID)
+		t.State = task.Failed
+		t.FinishTime = time.Now().UTC()
+		t.TerminationMessage = "container not found"
+		return
+	}
+	if resp.Container.State.Status == "exited" {
+		recordExit(t, resp.Container.State)
+		log.Printf("Container for task %v exited with code %d, marking task %v\n", t.ID, t.ExitCode, t.State)
+		return
+	}
+	// Tasks run by the process runtime have no network settings.
+	if resp.Container.NetworkSettings != nil {
+		t.HostPorts = resp.Container.NetworkSettings.Ports
+	}
+	t.AppliedLimits = resp.Limits()
+}
+
+// recordExit copies how a task's container ended into the task. A clean exit
+// completes the task, anything else fails it.
+func recordExit(t *task.Task, state *define.InspectContainerState) {
+	t.ExitCode = int(state.ExitCode)
+	t.OOMKilled = state.OOMKilled
+	t.FinishTime = state.FinishedAt.UTC()
+	if t.FinishTime.IsZero() {
+		t.FinishTime = time.Now().UTC()
+	}
+
+	switch {
+	case state.OOMKilled:
+		t.TerminationMessage = "killed for exceeding its memory limit"
+	case state.Error != "":
+		t.TerminationMessage = state.Error
+	case state.ExitCode != 0:
+		t.TerminationMessage = fmt.Sprintf("exited with code %d", state.ExitCode)
+	default:
+		t.TerminationMessage = ""
+	}
+
+	if t.ExitCode == 0 && !t.OOMKilled {
+		t.State = task.Completed
+	} else {
+		t.State = task.Failed
+	}
+}